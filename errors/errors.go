@@ -4,3 +4,39 @@ import "errors"
 
 var ErrFileTooLarge = errors.New("file too large")
 var ErrFileBadExtension = errors.New("file has an invalid extension")
+var ErrMissingPackMeta = errors.New("archive has no pack.mcmeta at its root")
+var ErrInvalidPackMeta = errors.New("invalid pack.mcmeta")
+var ErrNoCommonPackFormat = errors.New("the packs have no pack format in common")
+var ErrVersionCodeTaken = errors.New("a version with that version_code already exists")
+
+var ErrArchiveInvalid = errors.New("file is not a readable zip archive")
+var ErrArchiveTooLarge = errors.New("archive expands to more than the allowed size")
+var ErrArchiveTooManyEntries = errors.New("archive has too many entries")
+var ErrArchiveCompressionRatio = errors.New("archive entry is compressed suspiciously well")
+var ErrArchiveTooDeep = errors.New("archive has folders nested too deeply")
+var ErrArchiveUnsafePath = errors.New("archive entry has an unsafe path")
+var ErrArchiveSymlink = errors.New("archive contains a symbolic link")
+var ErrArchiveEncrypted = errors.New("archive contains an encrypted entry")
+var ErrArchiveSizeMismatch = errors.New("archive entry is larger than its header claims")
+
+var archiveErrors = []error{
+	ErrArchiveInvalid,
+	ErrArchiveTooLarge,
+	ErrArchiveTooManyEntries,
+	ErrArchiveCompressionRatio,
+	ErrArchiveTooDeep,
+	ErrArchiveUnsafePath,
+	ErrArchiveSymlink,
+	ErrArchiveEncrypted,
+	ErrArchiveSizeMismatch,
+}
+
+// IsArchiveError reports whether err means an uploaded archive was rejected as unsafe or unreadable.
+func IsArchiveError(err error) bool {
+	for _, archiveErr := range archiveErrors {
+		if errors.Is(err, archiveErr) {
+			return true
+		}
+	}
+	return false
+}
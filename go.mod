@@ -7,12 +7,16 @@ require (
 	github.com/jackc/pgx/v5 v5.7.1
 	github.com/labstack/echo/v4 v4.13.2
 	github.com/matoous/go-nanoid/v2 v2.1.0
+	github.com/microcosm-cc/bluemonday v1.0.27
 	github.com/mrz1836/go-sanitize v1.3.3
+	github.com/yuin/goldmark v1.7.8
 	golang.org/x/time v0.8.0
 )
 
 require (
+	github.com/aymerick/douceur v0.2.0 // indirect
 	github.com/golang-jwt/jwt v3.2.2+incompatible // indirect
+	github.com/gorilla/css v1.0.1 // indirect
 	github.com/jackc/puddle/v2 v2.2.2 // indirect
 	golang.org/x/sync v0.10.0 // indirect
 )
@@ -12,7 +12,10 @@ import (
 	"time"
 
 	"github.com/HoodieRocks/dph-api-2/routes"
+	"github.com/HoodieRocks/dph-api-2/utils/datapack"
 	"github.com/HoodieRocks/dph-api-2/utils/db"
+	files "github.com/HoodieRocks/dph-api-2/utils/files"
+	"github.com/HoodieRocks/dph-api-2/utils/stats"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
@@ -64,6 +67,53 @@ func startServer(ctx context.Context, wg *sync.WaitGroup) {
 
 	db.CreateTables(conn)
 
+	err = conn.SeedGameVersions(datapack.BundledGameVersions())
+
+	if err != nil {
+		log.Errorf("failed to seed minecraft versions: %v\n", err)
+		return
+	}
+
+	err = conn.BackfillVersionSort()
+
+	if err != nil {
+		log.Errorf("failed to backfill version sort keys: %v\n", err)
+		return
+	}
+
+	// record the files and contents of versions uploaded before they were kept, without holding up startup
+	go func() {
+		if err := files.BackfillVersionFiles(); err != nil {
+			log.Errorf("failed to backfill version files: %v\n", err)
+		}
+
+		if err := files.BackfillVersionContents(); err != nil {
+			log.Errorf("failed to backfill version contents: %v\n", err)
+		}
+
+		// namespaces are claimed from the content index, so this waits for it
+		if err := conn.BackfillNamespaceClaims(); err != nil {
+			log.Errorf("failed to backfill namespace claims: %v\n", err)
+		}
+	}()
+
+	// render changelogs and project bodies that were written before, or with an older renderer, in the background
+	go func() {
+		if err := conn.BackfillChangelogHTML(); err != nil {
+			log.Errorf("failed to backfill changelog html: %v\n", err)
+		}
+
+		if err := conn.BackfillProjectBodyHTML(); err != nil {
+			log.Errorf("failed to backfill project body html: %v\n", err)
+		}
+	}()
+
+	// roll download events up into daily stats until shutdown
+	go stats.RunRollups(ctx)
+
+	// write buffered downloads in batches, flushed for the last time on shutdown
+	stats.Downloads.Start()
+
 	e.Use(middleware.Gzip())
 	e.Use(middleware.Decompress())
 	e.Use(middleware.Secure())
@@ -72,13 +122,17 @@ func startServer(ctx context.Context, wg *sync.WaitGroup) {
 	e.GET("/", func(c echo.Context) error {
 		return c.String(http.StatusOK, "Welcome to the DPH API (go recreation)")
 	})
-	e.Static("/files", "./files")
+	// only icons are public files, versions are sent by their download routes which check access and count them
+	e.Static("/files/icons", "./files/icons")
 
 	// register routes
 	routes.RegisterUserRoutes(e)
 	routes.RegisterProjectRoutes(e)
 	routes.RegisterVersionRoutes(e)
 	routes.RegisterAdminRoutes(e)
+	routes.RegisterMinecraftRoutes(e)
+	routes.RegisterDatapackRoutes(e)
+	routes.RegisterNamespaceRoutes(e)
 
 	// start server
 	go func() {
@@ -91,6 +145,12 @@ func startServer(ctx context.Context, wg *sync.WaitGroup) {
 	defer cancelShutdown()
 
 	err = e.Shutdown(shutdownCtx)
+
+	// no more downloads can come in, write the ones still buffered before the pool closes
+	if flushErr := stats.Downloads.Close(); flushErr != nil {
+		log.Errorf("failed to flush downloads: %v\n", flushErr)
+	}
+
 	conn.Db.Close()
 	if err != nil {
 		fmt.Printf("Server shutdown error: %s\n", err)
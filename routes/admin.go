@@ -5,6 +5,7 @@ import (
 	"github.com/HoodieRocks/dph-api-2/auth"
 	"github.com/HoodieRocks/dph-api-2/utils/paging"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/HoodieRocks/dph-api-2/utils"
@@ -17,21 +18,22 @@ import (
 
 func listPendingReview(c echo.Context) error {
 	// Parse the query parameters for pagination
-	limit, offset, paginationErr := paging.GetPaginationModel(c)
+	req, paginationErr := paging.GetPageRequest(c, paging.Scope("pending"))
 	if paginationErr != nil {
 		return paginationErr
 	}
 
 	// Establish a connection to the database
 	conn := db.EstablishConnection()
-	projects, err := conn.GetProjectByStatus(StatusPending, limit, offset)
+	var startTime = time.Now()
+	projects, err := conn.GetProjectByStatus(StatusPending, req)
 
 	if err != nil {
 		log.Errorf("Query failed: %v\n", err)
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch projects")
 	}
 
-	return c.JSON(http.StatusOK, projects)
+	return c.JSON(http.StatusOK, paging.NewPage(req, projects, startTime))
 }
 
 func changeProjectStatus(c echo.Context) error {
@@ -69,6 +71,12 @@ func changeProjectStatus(c echo.Context) error {
 
 	// Update the project status
 	err = conn.UpdateProjectStatus(tx, project.ID, status)
+
+	// A project going live claims the namespaces of its versions nobody has claimed yet
+	if err == nil && strings.ToLower(status) == StatusLive {
+		err = conn.ClaimProjectNamespaces(tx, project.ID)
+	}
+
 	if err != nil {
 		newErr := tx.Rollback(context.Background())
 
@@ -161,10 +169,15 @@ func featureProject(c echo.Context) error {
 }
 
 func RegisterAdminRoutes(e *echo.Echo) {
-	// main entrypoint guard
-	e.Pre(auth.AllowRoles(auth.AdminRole, auth.ModeratorRole))
+	// main entrypoint guard, scoped to /admin so the public routes stay reachable
+	admin := e.Group("/admin", auth.AllowRoles(auth.AdminRole, auth.ModeratorRole))
+
+	admin.GET("/pending", listPendingReview, utils.DevRateLimiter(10))
+	admin.PUT("/projects/:id/status", changeProjectStatus, utils.DevRateLimiter(10))
+	admin.POST("/projects/:id/feature", featureProject, utils.DevRateLimiter(1))
 
-	e.GET("/admin/pending", listPendingReview, utils.DevRateLimiter(10))
-	e.PUT("/admin/projects/:id/status", changeProjectStatus, utils.DevRateLimiter(10))
-	e.POST("/admin/projects/:id/feature", featureProject, utils.DevRateLimiter(1))
+	admin.PUT("/namespaces/:ns", updateNamespaceClaim, utils.DevRateLimiter(10))
+	admin.DELETE("/namespaces/:ns", releaseNamespace, utils.DevRateLimiter(10))
+	admin.GET("/namespace-disputes", listNamespaceDisputes, utils.DevRateLimiter(10))
+	admin.PUT("/namespace-disputes/:id", resolveNamespaceDispute, utils.DevRateLimiter(10))
 }
@@ -0,0 +1,91 @@
+package routes
+
+import (
+	"html"
+	"net/http"
+	"strings"
+
+	"github.com/HoodieRocks/dph-api-2/utils/db"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+)
+
+// ProjectChangelog is the changelog of every version of a project, newest first. Changelog and ChangelogHTML
+// are all of them in one document, each under a heading with its version_code.
+type ProjectChangelog struct {
+	Project       string             `json:"project"`
+	Changelog     string             `json:"changelog"`
+	ChangelogHTML string             `json:"changelog_html"`
+	Versions      []VersionChangelog `json:"versions"`
+}
+
+// buildProjectChangelog puts the changelogs of versions together, in the order they are given.
+// The HTML is put together from the already sanitized HTML of each version, only the headings are added.
+func buildProjectChangelog(projectId string, versions []db.Version) ProjectChangelog {
+	var changelog = ProjectChangelog{Project: projectId, Versions: []VersionChangelog{}}
+
+	var raw, rendered []string
+
+	for _, version := range versions {
+		changelog.Versions = append(changelog.Versions, newVersionChangelog(version))
+
+		raw = append(raw, "## "+version.VersionCode+"\n\n"+version.Changelog)
+		rendered = append(rendered, "<h2>"+html.EscapeString(version.VersionCode)+"</h2>\n"+version.ChangelogHTML)
+	}
+
+	changelog.Changelog = strings.Join(raw, "\n\n")
+	changelog.ChangelogHTML = strings.Join(rendered, "\n")
+
+	return changelog
+}
+
+// getProjectChangelog returns the changelogs of every version of a project, newest first, both raw and
+// rendered to sanitized HTML. Versions without a changelog are left out.
+// If the project is a draft or pending, only its owner may read it.
+func getProjectChangelog(c echo.Context) error {
+	// Establish a connection to the database.
+	var conn = db.EstablishConnection()
+
+	// Get the project from the database.
+	project, err := conn.GetProjectByID(c.Param("id"))
+
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return echo.NewHTTPError(http.StatusNotFound, "no project with that id found")
+		}
+
+		log.Errorf("failed to fetch project: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch project")
+	}
+
+	// Check the user may read the project.
+	switch project.Status {
+	case StatusLive:
+		// Anyone may read the changelog of live projects.
+	case StatusDraft, StatusPending:
+		isOwner, err := IsUserProjectOwner(c, project)
+
+		if err != nil {
+			return err
+		}
+
+		if !isOwner {
+			return echo.NewHTTPError(http.StatusForbidden, "you can not access other's private projects")
+		}
+	default:
+		// If the project is in an illegal state, return a 500 error.
+		return echo.NewHTTPError(http.StatusInternalServerError, "illegal project state")
+	}
+
+	// Get the changelogs from the database.
+	versions, err := conn.ListVersionChangelogs(project.ID)
+
+	if err != nil {
+		log.Errorf("failed to fetch changelogs: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch changelogs")
+	}
+
+	return c.JSON(http.StatusOK, buildProjectChangelog(project.ID, versions))
+}
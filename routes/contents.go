@@ -0,0 +1,45 @@
+package routes
+
+import (
+	"net/http"
+
+	"github.com/HoodieRocks/dph-api-2/utils/datapack"
+	"github.com/HoodieRocks/dph-api-2/utils/db"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+)
+
+// VersionContents is the content index of a version, see datapack.Index.
+type VersionContents struct {
+	Version string `json:"version"`
+	datapack.Contents
+}
+
+// getVersionContents lists what the datapack of a version contains: its namespaces with how many functions
+// and other resources each has, and the resource location of every file but the functions.
+// ?kind=loot_table and ?namespace=minecraft narrow the resources down.
+// The same access rules as getVersionOnProject apply. If the version hasn't been indexed yet, it returns a 404 error.
+func getVersionContents(c echo.Context) error {
+	// Get the project and version, checking the user may see them.
+	_, version, err := getVisibleVersion(c)
+
+	if err != nil {
+		return err
+	}
+
+	// Versions from before indexing are indexed in the background after startup.
+	if !version.ContentsIndexed {
+		return echo.NewHTTPError(http.StatusNotFound, "the contents of this version haven't been indexed yet")
+	}
+
+	// Get the index from the database.
+	contents, err := db.EstablishConnection().GetVersionContents(version.ID, c.QueryParam("kind"), c.QueryParam("namespace"))
+
+	if err != nil {
+		log.Errorf("failed to fetch version contents: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch version contents")
+	}
+
+	return c.JSON(http.StatusOK, VersionContents{Version: version.ID, Contents: contents})
+}
@@ -0,0 +1,284 @@
+package routes
+
+import (
+	"archive/zip"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	derrors "github.com/HoodieRocks/dph-api-2/errors"
+	"github.com/HoodieRocks/dph-api-2/utils"
+	"github.com/HoodieRocks/dph-api-2/utils/datapack"
+	"github.com/HoodieRocks/dph-api-2/utils/db"
+	files "github.com/HoodieRocks/dph-api-2/utils/files"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+)
+
+// MaxPackSet caps how many versions a single conflict check or merge may take.
+const MaxPackSet = 50
+
+// PackRef names a version of a live project, by the project's id or slug and the version's id,
+// version_code or "latest" for the latest release.
+type PackRef struct {
+	Project string `json:"project"`
+	Version string `json:"version"`
+}
+
+// PackSetRequest is the body of a request about several packs, the versions are in load order.
+type PackSetRequest struct {
+	Versions []PackRef `json:"versions"`
+}
+
+// PackSummary identifies a pack of a load order in responses, Position is its index in the request.
+type PackSummary struct {
+	Position    int    `json:"position"`
+	Project     string `json:"project"`
+	Slug        string `json:"slug"`
+	Version     string `json:"version"`
+	VersionCode string `json:"version_code"`
+}
+
+// PackConflicts is the answer to a conflict check.
+type PackConflicts struct {
+	Packs []PackSummary `json:"packs"`
+	datapack.ConflictReport
+}
+
+// setPack is a pack of a request resolved to its project and version.
+type setPack struct {
+	project db.Project
+	version db.Version
+}
+
+func (pack setPack) summary(position int) PackSummary {
+	return PackSummary{
+		Position:    position,
+		Project:     pack.project.ID,
+		Slug:        pack.project.Slug,
+		Version:     pack.version.ID,
+		VersionCode: pack.version.VersionCode,
+	}
+}
+
+// resolvePackSet reads the versions of a request about several packs and looks them up, keeping their order.
+// Every version has to belong to a live project and be listed once, or it returns a 400 or 404 error.
+func resolvePackSet(c echo.Context) ([]setPack, error) {
+	// Parse the request body.
+	var body PackSetRequest
+
+	if err := c.Bind(&body); err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+
+	// Check the number of versions.
+	if len(body.Versions) < 2 {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "at least two versions are required")
+	}
+
+	if len(body.Versions) > MaxPackSet {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "too many versions, the limit is "+strconv.Itoa(MaxPackSet))
+	}
+
+	var conn = db.EstablishConnection()
+	var packs = make([]setPack, 0, len(body.Versions))
+	var seen = map[string]bool{}
+
+	for _, ref := range body.Versions {
+		// Find the project, by id or slug.
+		project, err := conn.GetProjectByID(ref.Project)
+
+		if err == pgx.ErrNoRows {
+			project, err = conn.GetProjectBySlug(ref.Project)
+		}
+
+		if err == pgx.ErrNoRows || (err == nil && project.Status != StatusLive) {
+			return nil, echo.NewHTTPError(http.StatusNotFound, "no project found for "+ref.Project)
+		}
+
+		if err != nil {
+			log.Errorf("failed to fetch project: %v\n", err)
+			return nil, echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch project")
+		}
+
+		// Find the version.
+		var version *db.Version
+
+		if ref.Version == "latest" {
+			version, err = conn.GetLatestVersion(project.ID, []string{db.ReleaseChannel}, "")
+		} else {
+			version, err = conn.GetVersionByRef(project.ID, ref.Version)
+		}
+
+		if err == pgx.ErrNoRows {
+			return nil, echo.NewHTTPError(http.StatusNotFound, "no version "+ref.Version+" found for "+ref.Project)
+		}
+
+		if err != nil {
+			log.Errorf("failed to fetch version: %v\n", err)
+			return nil, echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch version")
+		}
+
+		if seen[version.ID] {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "version "+version.ID+" is listed more than once")
+		}
+		seen[version.ID] = true
+
+		packs = append(packs, setPack{project: project, version: *version})
+	}
+
+	return packs, nil
+}
+
+// openPackSet opens the stored datapacks of packs. The returned function closes them again.
+func openPackSet(packs []setPack) ([]datapack.Pack, func(), error) {
+	var opened []datapack.Pack
+	var archives []*zip.ReadCloser
+
+	var closeAll = func() {
+		for _, archive := range archives {
+			archive.Close()
+		}
+	}
+
+	for _, pack := range packs {
+		archive, err := zip.OpenReader(files.LocalPath(pack.version.DownloadLink))
+
+		if err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+
+		archives = append(archives, archive)
+
+		// packs from before pack.mcmeta was checked may not have a usable one, they just have no overlays
+		meta, _ := datapack.ReadPackMeta(&archive.Reader)
+
+		opened = append(opened, datapack.Pack{Archive: &archive.Reader, Meta: meta})
+	}
+
+	return opened, closeAll, nil
+}
+
+// checkConflicts takes versions in load order and reports what happens when they are loaded together: files
+// several packs have that can't be merged and which pack wins, tags that merge fine or are replaced by a later
+// pack, identical files and namespaces several packs use. The stored archives are read, so the answer is
+// exactly what the game would see.
+func checkConflicts(c echo.Context) error {
+	// Resolve the versions.
+	packs, err := resolvePackSet(c)
+
+	if err != nil {
+		return err
+	}
+
+	// Open the stored datapacks.
+	archives, closeArchives, err := openPackSet(packs)
+
+	if err != nil {
+		log.Errorf("failed to open stored datapack: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to open datapacks")
+	}
+
+	defer closeArchives()
+
+	// Compare them.
+	report, err := datapack.FindConflicts(archives)
+
+	if err != nil {
+		log.Errorf("failed to check conflicts: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to check conflicts")
+	}
+
+	var conflicts = PackConflicts{Packs: []PackSummary{}, ConflictReport: report}
+	for i, pack := range packs {
+		conflicts.Packs = append(conflicts.Packs, pack.summary(i))
+	}
+
+	return c.JSON(http.StatusOK, conflicts)
+}
+
+// MergedPackName is what merged datapacks are called when downloaded.
+const MergedPackName = "merged-datapack.zip"
+
+// mergePacks takes versions in load order and sends a single datapack with all of them: tags are merged,
+// including the load and tick function tags, and the pack.mcmeta declares the formats every pack supports.
+// Files several packs have that can't be merged are a 409 error listing them, no pack silently wins.
+// Merged packs are cached by the versions they were merged from, in order, see files.MergedPackCacheLimits.
+func mergePacks(c echo.Context) error {
+	// Resolve the versions.
+	packs, err := resolvePackSet(c)
+
+	if err != nil {
+		return err
+	}
+
+	var versionIds []string
+	var names []string
+	var summaries = []PackSummary{}
+	for i, pack := range packs {
+		versionIds = append(versionIds, pack.version.ID)
+		names = append(names, pack.project.Title+" "+pack.version.VersionCode)
+		summaries = append(summaries, pack.summary(i))
+	}
+
+	var description = "Merged from " + strings.Join(names, ", ")
+	var link = files.MergedPackLink(versionIds, description)
+	var name = MergedPackName
+
+	// Send the cached pack if these versions were merged before.
+	if files.UseMergedPack(link) {
+		_, err = serveDownload(c, link, &name, nil)
+		return err
+	}
+
+	// Open the stored datapacks.
+	archives, closeArchives, err := openPackSet(packs)
+
+	if err != nil {
+		log.Errorf("failed to open stored datapack: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to open datapacks")
+	}
+
+	defer closeArchives()
+
+	// Merge them into the cache.
+	err = files.StoreMergedPack(link, archives, description)
+
+	if err != nil {
+
+		// Hand the conflicts back, so users can see which packs don't go together.
+		var conflictErr *datapack.MergeConflictError
+		if errors.As(err, &conflictErr) {
+			return c.JSON(http.StatusConflict, echo.Map{
+				"message":   "datapacks can't be merged",
+				"packs":     summaries,
+				"conflicts": conflictErr.Conflicts,
+			})
+		}
+
+		if err == derrors.ErrNoCommonPackFormat {
+			return echo.NewHTTPError(http.StatusConflict, err.Error())
+		}
+
+		log.Errorf("failed to merge datapacks: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to merge datapacks")
+	}
+
+	// Keep the cache in bounds, the new pack stays.
+	if err = files.PruneMergedPacks(link); err != nil {
+		log.Errorf("failed to prune merged datapacks: %v\n", err)
+	}
+
+	// Send the merged pack.
+	_, err = serveDownload(c, link, &name, nil)
+	return err
+}
+
+func RegisterDatapackRoutes(e *echo.Echo) {
+	e.POST("/datapacks/conflicts", checkConflicts, utils.DevRateLimiter(5))
+	e.POST("/datapacks/merge", mergePacks, utils.DevRateLimiter(5))
+}
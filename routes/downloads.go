@@ -0,0 +1,71 @@
+package routes
+
+import (
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/HoodieRocks/dph-api-2/utils/db"
+	files "github.com/HoodieRocks/dph-api-2/utils/files"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+)
+
+// serveDownload sends a stored file as an attachment named fileName. Range requests and conditional
+// requests against the sha256 ETag are handled by http.ServeContent. It reports whether a download
+// should be counted: a full response, or the first part of a ranged one, but not a resumed download
+// or a cache revalidation.
+func serveDownload(c echo.Context, link string, fileName *string, hashes *db.FileHashes) (bool, error) {
+	// Open the stored file.
+	file, err := os.Open(files.LocalPath(link))
+
+	if err != nil {
+		if os.IsNotExist(err) {
+			log.Errorf("stored file is missing: %s\n", link)
+			return false, echo.NewHTTPError(http.StatusNotFound, "file not found")
+		}
+
+		log.Errorf("failed to open stored file: %v\n", err)
+		return false, echo.NewHTTPError(http.StatusInternalServerError, "failed to open file")
+	}
+
+	defer file.Close()
+
+	info, err := file.Stat()
+
+	if err != nil {
+		log.Errorf("failed to stat stored file: %v\n", err)
+		return false, echo.NewHTTPError(http.StatusInternalServerError, "failed to open file")
+	}
+
+	// Name the download after the uploaded file, older versions only have the stored name.
+	var name = filepath.Base(link)
+	if fileName != nil {
+		name = *fileName
+	}
+
+	var header = c.Response().Header()
+
+	if disposition := mime.FormatMediaType("attachment", map[string]string{"filename": name}); disposition != "" {
+		header.Set(echo.HeaderContentDisposition, disposition)
+	} else {
+		header.Set(echo.HeaderContentDisposition, "attachment")
+	}
+
+	// The file behind a link never changes, so its hash makes a strong ETag.
+	if hashes != nil && hashes.SHA256 != "" {
+		header.Set("ETag", `"`+hashes.SHA256+`"`)
+	}
+
+	setDigestHeader(c, hashes)
+
+	http.ServeContent(c.Response(), c.Request(), name, info.ModTime(), file)
+
+	var status = c.Response().Status
+	var fromStart = strings.HasPrefix(c.Request().Header.Get("Range"), "bytes=0-")
+
+	return c.Request().Method == http.MethodGet && (status == http.StatusOK || status == http.StatusPartialContent && fromStart), nil
+}
@@ -0,0 +1,198 @@
+package routes
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/HoodieRocks/dph-api-2/utils/db"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+)
+
+// MaxHashLookups caps how many hashes a single batch lookup may resolve.
+const MaxHashLookups = 100
+
+// HashLookup is a stored file resolved to the project and version it belongs to.
+type HashLookup struct {
+	Project db.Project `json:"project"`
+	Version db.Version `json:"version"`
+	File    string     `json:"file"` // the role of the file, "primary", "resource_pack" or "addon"
+	FileID  string     `json:"file_id"`
+
+	Download string `json:"download,omitempty"` // the route that sends the matched file
+}
+
+// HashLookupRequest is the body of a batch hash lookup.
+type HashLookupRequest struct {
+	Algorithm string   `json:"algorithm" form:"algorithm"`
+	Hashes    []string `json:"hashes" form:"hashes"`
+}
+
+// normalizeHashes lowercases hashes and checks them against algo, returning a 400 error for anything invalid.
+func normalizeHashes(algo string, hashes []string) (string, []string, error) {
+	algo = strings.ToLower(algo)
+
+	if !slices.Contains(db.HASH_ALGORITHMS, algo) {
+		return "", nil, echo.NewHTTPError(http.StatusBadRequest, "algorithm must be one of: "+strings.Join(db.HASH_ALGORITHMS, ", "))
+	}
+
+	var normalized = make([]string, 0, len(hashes))
+	for _, hash := range hashes {
+		hash = strings.ToLower(strings.TrimSpace(hash))
+		if !db.ValidHash(algo, hash) {
+			return "", nil, echo.NewHTTPError(http.StatusBadRequest, "invalid "+algo+" hash: "+hash)
+		}
+		normalized = append(normalized, hash)
+	}
+
+	return algo, normalized, nil
+}
+
+// lookupHashes resolves hashes to versions of live projects. A hash that matches several versions,
+// e.g. a file re-uploaded unchanged, resolves to the oldest one.
+func lookupHashes(algo string, hashes []string) (map[string]HashLookup, error) {
+	var conn = db.EstablishConnection()
+
+	matches, err := conn.GetVersionsByHash(algo, hashes)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var projectIDs []string
+	for _, match := range matches {
+		projectIDs = append(projectIDs, match.Project)
+	}
+
+	projects, err := conn.GetProjectsByIDs(projectIDs)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Load the files of the versions, clients download the matched file by its route.
+	var versions = make([]*db.Version, 0, len(matches))
+	for i := range matches {
+		versions = append(versions, &matches[i].Version)
+	}
+
+	if err = loadVersionFiles(versions...); err != nil {
+		return nil, err
+	}
+
+	var lookups = make(map[string]HashLookup, len(matches))
+	for _, match := range matches {
+		if _, seen := lookups[match.MatchedHash]; seen {
+			continue
+		}
+		var lookup = HashLookup{
+			Project: projects[match.Project],
+			Version: match.Version,
+			File:    match.MatchedRole,
+			FileID:  match.MatchedFile,
+		}
+
+		// Files that aren't recorded yet have no id, only the primary one has a route without it.
+		switch {
+		case match.MatchedFile != "":
+			lookup.Download = match.Version.FileDownloadURL(db.VersionFile{ID: match.MatchedFile})
+		case match.MatchedRole == db.PrimaryFile:
+			lookup.Download = match.Version.DownloadURL()
+		}
+
+		lookups[match.MatchedHash] = lookup
+	}
+
+	return lookups, nil
+}
+
+// getVersionByHash resolves a single file hash to its project and version.
+// If the algorithm or hash is invalid, it returns a 400 error.
+// If no file of a live project has that hash, it returns a 404 error.
+func getVersionByHash(c echo.Context) error {
+	// Check the algorithm and hash from the parameters.
+	algo, hashes, err := normalizeHashes(c.Param("algo"), []string{c.Param("hash")})
+
+	if err != nil {
+		return err
+	}
+
+	// Look the hash up.
+	lookups, err := lookupHashes(algo, hashes)
+
+	if err != nil {
+		log.Errorf("failed to look up hash: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to look up hash")
+	}
+
+	lookup, ok := lookups[hashes[0]]
+
+	// If nothing matched, return a 404 error.
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "no version with that hash found")
+	}
+
+	return c.JSON(http.StatusOK, lookup)
+}
+
+// getVersionsByHashes resolves a batch of file hashes at once. It returns an object keyed by hash,
+// hashes that matched nothing are left out.
+// If the algorithm or any hash is invalid, or there are too many hashes, it returns a 400 error.
+func getVersionsByHashes(c echo.Context) error {
+	// Parse the request body.
+	var body HashLookupRequest
+
+	if err := c.Bind(&body); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+
+	// Check the number of hashes.
+	if len(body.Hashes) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "hashes are required")
+	}
+
+	if len(body.Hashes) > MaxHashLookups {
+		return echo.NewHTTPError(http.StatusBadRequest, "too many hashes, the limit is 100")
+	}
+
+	// Check the algorithm and hashes.
+	algo, hashes, err := normalizeHashes(body.Algorithm, body.Hashes)
+
+	if err != nil {
+		return err
+	}
+
+	// Look the hashes up.
+	lookups, err := lookupHashes(algo, hashes)
+
+	if err != nil {
+		log.Errorf("failed to look up hashes: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to look up hashes")
+	}
+
+	return c.JSON(http.StatusOK, lookups)
+}
+
+// setDigestHeader sends the stored digests of a download as an RFC 3230 Digest header.
+func setDigestHeader(c echo.Context, hashes *db.FileHashes) {
+	if hashes == nil {
+		return
+	}
+
+	var digests []string
+	for _, digest := range []struct{ name, hex string }{{"sha-256", hashes.SHA256}, {"sha-512", hashes.SHA512}} {
+		raw, err := hex.DecodeString(digest.hex)
+		if err != nil || len(raw) == 0 {
+			continue
+		}
+		digests = append(digests, digest.name+"="+base64.StdEncoding.EncodeToString(raw))
+	}
+
+	if len(digests) > 0 {
+		c.Response().Header().Set("Digest", strings.Join(digests, ","))
+	}
+}
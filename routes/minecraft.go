@@ -0,0 +1,147 @@
+package routes
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/HoodieRocks/dph-api-2/auth"
+	"github.com/HoodieRocks/dph-api-2/utils"
+	"github.com/HoodieRocks/dph-api-2/utils/datapack"
+	"github.com/HoodieRocks/dph-api-2/utils/db"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+)
+
+// listGameVersions returns the Minecraft version registry, oldest first.
+// It can be narrowed down to releases or snapshots with the type query parameter.
+func listGameVersions(c echo.Context) error {
+	var versionType = c.QueryParam("type")
+
+	if versionType != "" && versionType != datapack.ReleaseVersion && versionType != datapack.SnapshotVersion {
+		return echo.NewHTTPError(http.StatusBadRequest, "type must be release or snapshot")
+	}
+
+	var conn = db.EstablishConnection()
+
+	versions, err := conn.ListGameVersions(versionType)
+
+	if err != nil {
+		log.Errorf("failed to fetch minecraft versions: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch minecraft versions")
+	}
+
+	return c.JSON(http.StatusOK, versions)
+}
+
+func getGameVersion(c echo.Context) error {
+	var conn = db.EstablishConnection()
+
+	version, err := conn.GetGameVersion(c.Param("id"))
+
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return echo.NewHTTPError(http.StatusNotFound, "no minecraft version found")
+		}
+
+		log.Errorf("failed to fetch minecraft version: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch minecraft version")
+	}
+
+	return c.JSON(http.StatusOK, version)
+}
+
+// saveGameVersion creates or replaces a registry entry. The id comes from the path,
+// type, data_pack_format and resource_pack_format from the form.
+func saveGameVersion(c echo.Context) error {
+	var version = datapack.GameVersion{
+		ID:   c.Param("id"),
+		Type: c.FormValue("type"),
+	}
+
+	if version.Type != datapack.ReleaseVersion && version.Type != datapack.SnapshotVersion {
+		return echo.NewHTTPError(http.StatusBadRequest, "type must be release or snapshot")
+	}
+
+	dataPackFormat, err := strconv.Atoi(c.FormValue("data_pack_format"))
+	if err != nil || dataPackFormat <= 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "data_pack_format must be a positive number")
+	}
+
+	resourcePackFormat, err := strconv.Atoi(c.FormValue("resource_pack_format"))
+	if err != nil || resourcePackFormat <= 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "resource_pack_format must be a positive number")
+	}
+
+	version.DataPackFormat = dataPackFormat
+	version.ResourcePackFormat = resourcePackFormat
+
+	var conn = db.EstablishConnection()
+
+	tx, err := conn.Db.Begin(context.Background())
+
+	if err != nil {
+		log.Errorf("failed to begin transaction: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to save minecraft version")
+	}
+
+	if err = conn.SaveGameVersion(tx, version); err != nil {
+		newErr := tx.Rollback(context.Background())
+
+		if newErr != nil {
+			log.Errorf("failed to rollback: %v\n", newErr)
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to save minecraft version")
+		}
+
+		log.Errorf("failed to save minecraft version: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to save minecraft version")
+	}
+
+	if err = tx.Commit(context.Background()); err != nil {
+		log.Errorf("failed to commit transaction: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to save minecraft version")
+	}
+
+	return c.JSON(http.StatusOK, version)
+}
+
+func deleteGameVersion(c echo.Context) error {
+	var conn = db.EstablishConnection()
+
+	tx, err := conn.Db.Begin(context.Background())
+
+	if err != nil {
+		log.Errorf("failed to begin transaction: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete minecraft version")
+	}
+
+	if err = conn.DeleteGameVersion(tx, c.Param("id")); err != nil {
+		newErr := tx.Rollback(context.Background())
+
+		if newErr != nil {
+			log.Errorf("failed to rollback: %v\n", newErr)
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete minecraft version")
+		}
+
+		log.Errorf("failed to delete minecraft version: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete minecraft version")
+	}
+
+	if err = tx.Commit(context.Background()); err != nil {
+		log.Errorf("failed to commit transaction: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete minecraft version")
+	}
+
+	return c.String(http.StatusOK, "minecraft version deleted")
+}
+
+func RegisterMinecraftRoutes(e *echo.Echo) {
+	e.GET("/minecraft/versions", listGameVersions, utils.DevRateLimiter(100))
+	e.GET("/minecraft/versions/:id", getGameVersion, utils.DevRateLimiter(100))
+
+	admin := e.Group("/admin/minecraft", auth.AllowRoles(auth.AdminRole))
+	admin.PUT("/versions/:id", saveGameVersion, utils.DevRateLimiter(10))
+	admin.DELETE("/versions/:id", deleteGameVersion, utils.DevRateLimiter(10))
+}
@@ -0,0 +1,580 @@
+package routes
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/HoodieRocks/dph-api-2/auth"
+	"github.com/HoodieRocks/dph-api-2/utils"
+	"github.com/HoodieRocks/dph-api-2/utils/datapack"
+	"github.com/HoodieRocks/dph-api-2/utils/db"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+)
+
+// MaxDisputeReasonLength is how long the reason for a namespace dispute can be.
+const MaxDisputeReasonLength = 2000
+
+// NamespaceOwner is a namespace claim together with the project that has it.
+type NamespaceOwner struct {
+	db.NamespaceClaim
+	Owner *db.Project `json:"owner,omitempty"` // left out while the project isn't live
+}
+
+// checkNamespaceClaims looks up who claimed the namespaces an upload to project uses. It returns the namespaces
+// other projects claimed with the block policy, and a warning for each one they claimed with the warn policy.
+func checkNamespaceClaims(project db.Project, namespaces []string) ([]string, []string, error) {
+	var conn = db.EstablishConnection()
+	claims, err := conn.ListNamespaceClaims(namespaces)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var blocked []string
+	var others []string
+	for _, claim := range claims {
+		if claim.Project != project.ID {
+			others = append(others, claim.Project)
+		}
+	}
+
+	if len(others) == 0 {
+		return nil, nil, nil
+	}
+
+	owners, err := conn.GetProjectsByIDs(others)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var warnings []string
+	for _, claim := range claims {
+		if claim.Project == project.ID {
+			continue
+		}
+
+		if claim.Policy == db.NamespaceBlock {
+			blocked = append(blocked, claim.Namespace)
+			continue
+		}
+
+		// drafts stay private, so only live owners are named
+		var owner = "another project"
+		if other, ok := owners[claim.Project]; ok && other.Status == StatusLive {
+			owner = "the project " + other.Slug
+		}
+
+		warnings = append(warnings, "namespace "+claim.Namespace+" is claimed by "+owner)
+	}
+
+	return blocked, warnings, nil
+}
+
+// getNamespaceParam reads the :ns parameter, returning a 400 error if it isn't a namespace
+// and a 404 error if it is one of the shared namespaces nobody can claim.
+func getNamespaceParam(c echo.Context) (string, error) {
+	var namespace = c.Param("ns")
+
+	if !datapack.ValidNamespace(namespace) {
+		return "", echo.NewHTTPError(http.StatusBadRequest, "invalid namespace")
+	}
+
+	if slices.Contains(datapack.SharedNamespaces, namespace) {
+		return "", echo.NewHTTPError(http.StatusNotFound, "namespace "+namespace+" is shared and can't be claimed")
+	}
+
+	return namespace, nil
+}
+
+// getNamespace returns who owns a namespace. A namespace is claimed by the first live version using it,
+// so a 404 error means nobody has published a pack with it yet.
+func getNamespace(c echo.Context) error {
+	// Get the namespace from the request.
+	namespace, err := getNamespaceParam(c)
+
+	if err != nil {
+		return err
+	}
+
+	// Get the claim from the database.
+	var conn = db.EstablishConnection()
+	claim, err := conn.GetNamespaceClaim(namespace)
+
+	if err != nil {
+
+		if err == pgx.ErrNoRows {
+			return echo.NewHTTPError(http.StatusNotFound, "namespace "+namespace+" isn't claimed")
+		}
+
+		log.Errorf("failed to fetch namespace claim: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch namespace")
+	}
+
+	// Add the owner, as long as it can be seen.
+	var owner = NamespaceOwner{NamespaceClaim: claim}
+	project, err := conn.GetProjectByID(claim.Project)
+
+	if err != nil {
+		log.Errorf("failed to fetch project: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch namespace")
+	}
+
+	// Drafts stay private, down to their id.
+	if project.Status == StatusLive {
+		owner.Owner = &project
+	} else {
+		owner.Project = ""
+	}
+
+	return c.JSON(http.StatusOK, owner)
+}
+
+// getClaimedNamespace returns the claim on the :ns parameter, with a 404 error if nobody has claimed it.
+func getClaimedNamespace(c echo.Context) (db.NamespaceClaim, error) {
+	namespace, err := getNamespaceParam(c)
+
+	if err != nil {
+		return db.NamespaceClaim{}, err
+	}
+
+	claim, err := db.EstablishConnection().GetNamespaceClaim(namespace)
+
+	if err != nil {
+
+		if err == pgx.ErrNoRows {
+			return claim, echo.NewHTTPError(http.StatusNotFound, "namespace "+namespace+" isn't claimed")
+		}
+
+		log.Errorf("failed to fetch namespace claim: %v\n", err)
+		return claim, echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch namespace")
+	}
+
+	return claim, nil
+}
+
+// transferNamespace lets the owner of the project that claimed a namespace hand it to another project,
+// given as the project form value. The other project has to be live, even if it is the user's own, so
+// the namespace never goes to a project nobody else can see.
+func transferNamespace(c echo.Context) error {
+	// Get the claim.
+	claim, err := getClaimedNamespace(c)
+
+	if err != nil {
+		return err
+	}
+
+	// Retrieve the user from the token.
+	user, err := auth.GetContextUser(c)
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	// Check the user owns the project that has the namespace.
+	var conn = db.EstablishConnection()
+	project, err := conn.GetProjectByID(claim.Project)
+
+	if err != nil {
+		log.Errorf("failed to fetch project: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch project")
+	}
+
+	if project.Author != user.ID {
+		return echo.NewHTTPError(http.StatusForbidden, "only the owner of the namespace can transfer it")
+	}
+
+	// Find the project it goes to.
+	target, err := conn.GetProjectByID(c.FormValue("project"))
+
+	if err == pgx.ErrNoRows || (err == nil && target.Status != StatusLive && target.Author != user.ID) {
+		return echo.NewHTTPError(http.StatusNotFound, "no project with that id found")
+	}
+
+	if err != nil {
+		log.Errorf("failed to fetch project: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch project")
+	}
+
+	if target.Status != StatusLive {
+		return echo.NewHTTPError(http.StatusBadRequest, "namespaces can only be transferred to live projects")
+	}
+
+	// Transfer the namespace in a transaction.
+	tx, err := conn.Db.Begin(context.Background())
+
+	if err != nil {
+		log.Errorf("failed to initialise transaction: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to transfer namespace")
+	}
+
+	err = conn.TransferNamespace(tx, claim.Namespace, target.ID)
+
+	// Disputes against the old owner are settled by the transfer.
+	if err == nil {
+		err = conn.CloseNamespaceDisputes(tx, claim.Namespace, target.ID, user.ID)
+	}
+
+	// If the transfer failed, rollback and return a 500 error.
+	if err != nil {
+		newErr := tx.Rollback(context.Background())
+
+		if newErr != nil {
+			log.Errorf("failed to rollback transaction: %v\n", newErr)
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to transfer namespace")
+		}
+		log.Errorf("failed to transfer namespace: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to transfer namespace")
+	}
+
+	// Commit the transaction.
+	err = tx.Commit(context.Background())
+
+	if err != nil {
+		log.Errorf("failed to commit transaction: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to transfer namespace")
+	}
+
+	return c.String(http.StatusOK, "namespace transferred")
+}
+
+// disputeNamespace asks moderators to give a namespace another project claimed to one of the user's projects.
+// It takes the project and the reason as form values.
+func disputeNamespace(c echo.Context) error {
+	// Get the claim.
+	claim, err := getClaimedNamespace(c)
+
+	if err != nil {
+		return err
+	}
+
+	// Check the reason.
+	var reason = strings.TrimSpace(c.FormValue("reason"))
+
+	if reason == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "missing reason")
+	}
+
+	if len(reason) > MaxDisputeReasonLength {
+		return echo.NewHTTPError(http.StatusBadRequest, "reason is too long")
+	}
+
+	// Retrieve the user from the token.
+	user, err := auth.GetContextUser(c)
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	// Check the user owns the project asking for the namespace.
+	var conn = db.EstablishConnection()
+	project, err := conn.GetProjectByID(c.FormValue("project"))
+
+	if err == pgx.ErrNoRows || (err == nil && project.Author != user.ID) {
+		return echo.NewHTTPError(http.StatusNotFound, "no project with that id found")
+	}
+
+	if err != nil {
+		log.Errorf("failed to fetch project: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch project")
+	}
+
+	if project.ID == claim.Project {
+		return echo.NewHTTPError(http.StatusBadRequest, "the project already owns this namespace")
+	}
+
+	// One open dispute per project and namespace is enough.
+	open, err := conn.HasOpenNamespaceDispute(claim.Namespace, project.ID)
+
+	if err != nil {
+		log.Errorf("failed to check namespace disputes: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to dispute namespace")
+	}
+
+	if open {
+		return echo.NewHTTPError(http.StatusConflict, "the project already disputes this namespace")
+	}
+
+	// Open the dispute in a transaction.
+	var dispute = db.NamespaceDispute{
+		Namespace: claim.Namespace,
+		Project:   project.ID,
+		Reason:    reason,
+		Status:    db.DisputeOpen,
+		Creation:  time.Now(),
+	}
+
+	tx, err := conn.Db.Begin(context.Background())
+
+	if err != nil {
+		log.Errorf("failed to initialise transaction: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to dispute namespace")
+	}
+
+	dispute.ID, err = conn.CreateNamespaceDispute(tx, dispute)
+
+	// If the creation failed, rollback and return a 500 error.
+	if err != nil {
+		newErr := tx.Rollback(context.Background())
+
+		if newErr != nil {
+			log.Errorf("failed to rollback transaction: %v\n", newErr)
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to dispute namespace")
+		}
+		log.Errorf("failed to create namespace dispute: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to dispute namespace")
+	}
+
+	// Commit the transaction.
+	err = tx.Commit(context.Background())
+
+	if err != nil {
+		log.Errorf("failed to commit transaction: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to dispute namespace")
+	}
+
+	return c.JSON(http.StatusCreated, dispute)
+}
+
+// listNamespaceDisputes lists the disputes moderators still have to resolve, oldest first.
+func listNamespaceDisputes(c echo.Context) error {
+	disputes, err := db.EstablishConnection().ListOpenNamespaceDisputes()
+
+	if err != nil {
+		log.Errorf("failed to fetch namespace disputes: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch namespace disputes")
+	}
+
+	return c.JSON(http.StatusOK, disputes)
+}
+
+// resolveNamespaceDispute closes a dispute with the status form value. An accepted dispute transfers the
+// namespace to the project that opened it.
+func resolveNamespaceDispute(c echo.Context) error {
+	// Check the resolution.
+	var status = c.FormValue("status")
+
+	if status != db.DisputeAccepted && status != db.DisputeRejected {
+		return echo.NewHTTPError(http.StatusBadRequest, "status must be one of: accepted, rejected")
+	}
+
+	// Retrieve the moderator from the token.
+	moderator, err := auth.GetContextUser(c)
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	// Get the dispute.
+	var conn = db.EstablishConnection()
+	dispute, err := conn.GetNamespaceDispute(c.Param("id"))
+
+	if err != nil {
+
+		if err == pgx.ErrNoRows {
+			return echo.NewHTTPError(http.StatusNotFound, "no dispute found")
+		}
+
+		log.Errorf("failed to fetch namespace dispute: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch namespace dispute")
+	}
+
+	if dispute.Status != db.DisputeOpen {
+		return echo.NewHTTPError(http.StatusConflict, "the dispute has already been resolved")
+	}
+
+	// Resolve it, and transfer the namespace if it was accepted, in a transaction.
+	tx, err := conn.Db.Begin(context.Background())
+
+	if err != nil {
+		log.Errorf("failed to initialise transaction: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to resolve namespace dispute")
+	}
+
+	err = conn.ResolveNamespaceDispute(tx, dispute.ID, status, moderator.ID)
+
+	if err == nil && status == db.DisputeAccepted {
+		err = conn.TransferNamespace(tx, dispute.Namespace, dispute.Project)
+	}
+
+	// The other disputes for the namespace are settled by the transfer.
+	if err == nil && status == db.DisputeAccepted {
+		err = conn.CloseNamespaceDisputes(tx, dispute.Namespace, dispute.Project, moderator.ID)
+	}
+
+	// If the update failed, rollback and return a 500 error.
+	if err != nil {
+		newErr := tx.Rollback(context.Background())
+
+		if newErr != nil {
+			log.Errorf("failed to rollback transaction: %v\n", newErr)
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to resolve namespace dispute")
+		}
+		log.Errorf("failed to resolve namespace dispute: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to resolve namespace dispute")
+	}
+
+	// Commit the transaction.
+	err = tx.Commit(context.Background())
+
+	if err != nil {
+		log.Errorf("failed to commit transaction: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to resolve namespace dispute")
+	}
+
+	var now = time.Now()
+	dispute.Status = status
+	dispute.Resolved = &now
+	dispute.ResolvedBy = &moderator.ID
+
+	return c.JSON(http.StatusOK, dispute)
+}
+
+// updateNamespaceClaim lets moderators set the policy of a namespace and who owns it, with the policy and
+// project form values. Giving a project claims the namespace for it even if nobody had.
+func updateNamespaceClaim(c echo.Context) error {
+	// Get the namespace from the request.
+	namespace, err := getNamespaceParam(c)
+
+	if err != nil {
+		return err
+	}
+
+	var policy = c.FormValue("policy")
+	var projectId = c.FormValue("project")
+
+	if policy == "" && projectId == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "missing policy or project")
+	}
+
+	if policy != "" && !slices.Contains(db.NAMESPACE_POLICIES, policy) {
+		return echo.NewHTTPError(http.StatusBadRequest, "policy must be one of: "+strings.Join(db.NAMESPACE_POLICIES, ", "))
+	}
+
+	// Retrieve the moderator from the token.
+	moderator, err := auth.GetContextUser(c)
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	// Check the namespace is claimed, or about to be.
+	var conn = db.EstablishConnection()
+
+	if projectId == "" {
+		if _, err = getClaimedNamespace(c); err != nil {
+			return err
+		}
+	} else if _, err = conn.GetProjectByID(projectId); err != nil {
+
+		if err == pgx.ErrNoRows {
+			return echo.NewHTTPError(http.StatusNotFound, "no project with that id found")
+		}
+
+		log.Errorf("failed to fetch project: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch project")
+	}
+
+	// Update the claim in a transaction.
+	tx, err := conn.Db.Begin(context.Background())
+
+	if err != nil {
+		log.Errorf("failed to initialise transaction: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update namespace")
+	}
+
+	if projectId != "" {
+		err = conn.TransferNamespace(tx, namespace, projectId)
+	}
+
+	if err == nil && projectId != "" {
+		err = conn.CloseNamespaceDisputes(tx, namespace, projectId, moderator.ID)
+	}
+
+	if err == nil && policy != "" {
+		err = conn.SetNamespacePolicy(tx, namespace, policy)
+	}
+
+	// If the update failed, rollback and return a 500 error.
+	if err != nil {
+		newErr := tx.Rollback(context.Background())
+
+		if newErr != nil {
+			log.Errorf("failed to rollback transaction: %v\n", newErr)
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to update namespace")
+		}
+		log.Errorf("failed to update namespace claim: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update namespace")
+	}
+
+	// Commit the transaction.
+	err = tx.Commit(context.Background())
+
+	if err != nil {
+		log.Errorf("failed to commit transaction: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update namespace")
+	}
+
+	claim, err := getClaimedNamespace(c)
+
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, claim)
+}
+
+// releaseNamespace lets moderators drop the claim on a namespace. The next live version using it claims it again.
+func releaseNamespace(c echo.Context) error {
+	// Get the claim.
+	claim, err := getClaimedNamespace(c)
+
+	if err != nil {
+		return err
+	}
+
+	// Drop it in a transaction.
+	var conn = db.EstablishConnection()
+	tx, err := conn.Db.Begin(context.Background())
+
+	if err != nil {
+		log.Errorf("failed to initialise transaction: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to release namespace")
+	}
+
+	err = conn.ReleaseNamespace(tx, claim.Namespace)
+
+	// If the deletion failed, rollback and return a 500 error.
+	if err != nil {
+		newErr := tx.Rollback(context.Background())
+
+		if newErr != nil {
+			log.Errorf("failed to rollback transaction: %v\n", newErr)
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to release namespace")
+		}
+		log.Errorf("failed to release namespace: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to release namespace")
+	}
+
+	// Commit the transaction.
+	err = tx.Commit(context.Background())
+
+	if err != nil {
+		log.Errorf("failed to commit transaction: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to release namespace")
+	}
+
+	return c.String(http.StatusOK, "namespace released")
+}
+
+func RegisterNamespaceRoutes(e *echo.Echo) {
+	e.GET("/namespaces/:ns", getNamespace, utils.DevRateLimiter(100))
+	e.PUT("/namespaces/:ns/owner", transferNamespace, utils.DevRateLimiter(10))
+	e.POST("/namespaces/:ns/disputes", disputeNamespace, utils.DevRateLimiter(1))
+}
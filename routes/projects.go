@@ -2,9 +2,11 @@ package routes
 
 import (
 	"context"
+	"fmt"
 	"github.com/HoodieRocks/dph-api-2/auth"
 	"github.com/HoodieRocks/dph-api-2/utils/paging"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -24,36 +26,117 @@ const (
 )
 
 func listProjects(c echo.Context) error {
-	// Define the structure of the search results.
-	type SearchResults struct {
-		Time    float64      `json:"time"` // Search time in seconds
-		Count   int          `json:"count"`
-		Results []db.Project `json:"results"`
-	}
-
 	// Establish a connection to the database
 	var conn = db.EstablishConnection()
 
+	// Parse the query parameters for sorting and filtering
+	sort, sortErr := getProjectSort(c, nil)
+	if sortErr != nil {
+		return sortErr
+	}
+	filter := getProjectFilter(c)
+
 	// Parse the query parameters for pagination
-	limit, offset, paginationErr := paging.GetPaginationModel(c)
+	req, paginationErr := paging.GetPageRequest(c, projectListScope("projects", sort, filter))
 	if paginationErr != nil {
 		return paginationErr
 	}
 
 	var startTime = time.Now()
 	// Retrieve the projects from the database
-	results, err := conn.ListProjects(limit, offset, "downloads")
+	results, err := conn.ListProjects(filter, sort, req)
 	if err != nil {
 		log.Errorf("failed to fetch projects: %v\n", err)
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch projects")
 	}
 
+	// Count every project matching the filter, for pagination
+	total, err := conn.CountProjects(filter)
+	if err != nil {
+		log.Errorf("failed to count projects: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch projects")
+	}
+
 	// Return the projects as JSON
-	return c.JSON(http.StatusOK, SearchResults{
-		Time:    time.Since(startTime).Seconds(),
-		Count:   len(results),
-		Results: results,
-	})
+	var page = paging.NewPage(req, results, startTime)
+	page.Total = &total
+	return c.JSON(http.StatusOK, page)
+}
+
+// projectListScope fingerprints a project listing for its cursors.
+func projectListScope(endpoint string, sort db.ProjectSort, filter db.ProjectFilter, extra ...string) string {
+	var parts = []string{endpoint, sort.Name, strconv.FormatBool(sort.Desc), fmt.Sprintf("%v", filter)}
+	return paging.Scope(append(parts, extra...)...)
+}
+
+// getProjectSort reads the sort and order query parameters,
+// only the sorts whitelisted in db.PROJECT_SORTS are accepted.
+// Searches pass their relevance ordering, which then becomes the default.
+func getProjectSort(c echo.Context, relevance *db.ProjectSort) (db.ProjectSort, error) {
+	var name = strings.ToLower(c.QueryParam("sort"))
+	if name == "" {
+		name = db.DefaultProjectSort
+		if relevance != nil {
+			name = relevance.Name
+		}
+	}
+
+	var desc bool
+	switch strings.ToLower(c.QueryParam("order")) {
+	case "asc":
+		desc = false
+	case "desc":
+		desc = true
+	case "":
+		// titles read naturally A-Z, everything else is best first
+		desc = name != "title"
+	default:
+		return db.ProjectSort{}, echo.NewHTTPError(http.StatusBadRequest, "order must be asc or desc")
+	}
+
+	if relevance != nil && name == relevance.Name {
+		var sort = *relevance
+		sort.Desc = desc
+		return sort, nil
+	}
+
+	sort, ok := db.GetProjectSort(name, desc)
+	if !ok {
+		return db.ProjectSort{}, echo.NewHTTPError(http.StatusBadRequest, "unknown sort")
+	}
+
+	return sort, nil
+}
+
+// getProjectFilter reads the project filters from the query parameters.
+// Each filter can be repeated or comma separated, e.g. ?category=magic,tech&version=1.21
+func getProjectFilter(c echo.Context) db.ProjectFilter {
+	return db.ProjectFilter{
+		Categories: getListParam(c, "category"),
+		Versions:   getListParam(c, "version"),
+		Licenses:   getListParam(c, "license"),
+		Authors:    getListParam(c, "author"),
+	}
+}
+
+func getListParam(c echo.Context, name string) []string {
+	var values []string
+	for _, raw := range c.QueryParams()[name] {
+		values = append(values, splitList(raw)...)
+	}
+	return values
+}
+
+// splitList splits a comma separated value, dropping blank entries.
+func splitList(raw string) []string {
+	var values []string
+	for _, value := range strings.Split(raw, ",") {
+		value = strings.TrimSpace(value)
+		if value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
 }
 
 func getProjectById(c echo.Context) error {
@@ -270,13 +353,15 @@ func createProject(c echo.Context) error {
 		Slug:        slug,
 		Author:      user.ID,
 		Description: description,
-		Body:        body,
 		Creation:    time.Now(),
 		Updated:     time.Now(),
 		Category:    category,
 		Icon:        &iconPath,
 	}
 
+	// Set the body, rendering it to HTML once so reads don't have to.
+	project.SetBody(body)
+
 	// Start a transaction
 	tx, err := conn.Db.Begin(context.Background())
 
@@ -397,9 +482,33 @@ func updateProject(c echo.Context) error {
 	return c.JSON(http.StatusOK, project)
 }
 
+// SearchPage is a page of search results, along with facet counts over every match.
+type SearchPage struct {
+	paging.Page[db.SearchResult]
+	Facets db.Facets `json:"facets"`
+}
+
+// ftsSearch runs a ranked full-text search over the title, description and body of live projects.
+// It accepts the same filters as listProjects, e.g. ?category=magic&license=MIT, and highlights the
+// matches in each result's headline.
 func ftsSearch(c echo.Context) error {
 	// Get the search query from the request parameters
-	query := c.QueryParam("q")
+	query := strings.TrimSpace(c.QueryParam("q"))
+	if query == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "missing search query")
+	}
+
+	// Parse the query parameters for sorting, filtering and pagination
+	sort, sortErr := getProjectSort(c, &db.FTS_RELEVANCE)
+	if sortErr != nil {
+		return sortErr
+	}
+	filter := getProjectFilter(c)
+
+	req, paginationErr := paging.GetPageRequest(c, projectListScope("fts", sort, filter, query))
+	if paginationErr != nil {
+		return paginationErr
+	}
 
 	// Establish a connection to the database
 	conn := db.EstablishConnection()
@@ -408,51 +517,54 @@ func ftsSearch(c echo.Context) error {
 	startTime := time.Now()
 
 	// Perform the full-text search
-	rows, err := conn.FTSSearchProjects(query)
-
-	// Define the search results structure
-	type SearchResults struct {
-		Time    float64      `json:"time"` // Search time in seconds
-		Count   int          `json:"count"`
-		Results []db.Project `json:"results"`
-	}
+	results, err := conn.FTSSearchProjects(query, filter, sort, req)
 
 	// Handle errors during the search
 	if err != nil {
-		if err == pgx.ErrNoRows {
-			// Return empty results if no rows are found
-			return c.JSON(http.StatusOK, SearchResults{
-				Time:    time.Since(startTime).Seconds(),
-				Count:   0,
-				Results: make([]db.Project, 0),
-			})
-		}
-
-		// Log and return an error if the search fails
 		log.Errorf("failed to search: %v\n", err.Error())
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to search")
 	}
 
-	// Collect the search results
-	results, err := pgx.CollectRows(rows, pgx.RowToStructByName[db.Project])
+	// Count every match, for pagination
+	total, err := conn.CountFTSSearchProjects(query, filter)
+	if err != nil {
+		log.Errorf("failed to count search: %v\n", err.Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to search")
+	}
 
-	// Handle errors during the search result collection
+	// Break the whole match set down for narrowing the search.
+	facets, err := conn.FTSSearchFacets(query, filter)
 	if err != nil {
-		log.Errorf("failed to collect search: %v\n", err.Error())
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to collect search")
+		log.Errorf("failed to count search facets: %v\n", err.Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to search")
 	}
 
 	// Return the search results
-	return c.JSON(http.StatusOK, SearchResults{
-		Time:    time.Since(startTime).Seconds(),
-		Count:   len(results),
-		Results: results,
-	})
+	var page = SearchPage{Page: paging.NewPage(req, results, startTime), Facets: facets}
+	page.Total = &total
+	return c.JSON(http.StatusOK, page)
 }
 
+// search finds live projects whose title or slug is close to the query, tolerating typos,
+// or whose title, slug or description contains it. It accepts the same filters as listProjects.
 func search(c echo.Context) error {
 	// Get the query parameter from the request.
-	var query = c.QueryParam("q")
+	var query = strings.TrimSpace(c.QueryParam("q"))
+	if query == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "missing search query")
+	}
+
+	// Parse the query parameters for sorting, filtering and pagination.
+	sort, sortErr := getProjectSort(c, &db.TRIGRAM_RELEVANCE)
+	if sortErr != nil {
+		return sortErr
+	}
+	filter := getProjectFilter(c)
+
+	req, paginationErr := paging.GetPageRequest(c, projectListScope("search", sort, filter, query))
+	if paginationErr != nil {
+		return paginationErr
+	}
 
 	// Establish a connection to the database.
 	var conn = db.EstablishConnection()
@@ -461,46 +573,149 @@ func search(c echo.Context) error {
 	var startTime = time.Now()
 
 	// Search the projects table for projects that match the query.
-	var rows, err = conn.SearchProjects(query)
+	var results, err = conn.SearchProjects(query, filter, sort, req)
 
-	// Define the structure of the search results.
-	type SearchResults struct {
-		Time    float64      `json:"time"` // Search time in seconds
-		Count   int          `json:"count"`
-		Results []db.Project `json:"results"`
+	// Handle errors during the search.
+	if err != nil {
+		log.Errorf("failed to search: %v\n", err.Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to search")
 	}
 
-	// Handle errors during the search.
+	// Count every match, for pagination.
+	total, err := conn.CountSearchProjects(query, filter)
 	if err != nil {
-		// If no rows are found, return empty results.
-		if err == pgx.ErrNoRows {
-			return c.JSON(http.StatusOK, SearchResults{
-				Time:    time.Since(startTime).Seconds(),
-				Count:   0,
-				Results: make([]db.Project, 0),
-			})
-		}
+		log.Errorf("failed to count search: %v\n", err.Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to search")
+	}
 
-		// Log and return an error if the search fails.
-		log.Errorf("failed to search: %v\n", err.Error())
+	// Break the whole match set down for narrowing the search.
+	facets, err := conn.SearchFacets(query, filter)
+	if err != nil {
+		log.Errorf("failed to count search facets: %v\n", err.Error())
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to search")
 	}
 
-	// Collect the search results.
-	results, err := pgx.CollectRows(rows, pgx.RowToStructByName[db.Project])
+	// Return the search results.
+	var page = SearchPage{Page: paging.NewPage(req, results, startTime), Facets: facets}
+	page.Total = &total
+	return c.JSON(http.StatusOK, page)
+}
+
+// getContentPattern reads a resource location or kind pattern. Only the characters of resource locations,
+// / and * wildcards are allowed.
+func getContentPattern(c echo.Context, name string) (string, error) {
+	var pattern = strings.ToLower(strings.TrimSpace(c.QueryParam(name)))
+
+	for _, char := range pattern {
+		if !(char >= 'a' && char <= 'z' || char >= '0' && char <= '9' || strings.ContainsRune("_-./:*", char)) {
+			return "", echo.NewHTTPError(http.StatusBadRequest, name+" may only contain a-z, 0-9, _, -, ., /, : and *")
+		}
+	}
+
+	return pattern, nil
+}
+
+// searchContents finds live projects by the resources in their latest version that hasn't been yanked, e.g.
+// ?kind=tags/function&location=minecraft:tick for packs that hook into the tick function tag, or
+// ?kind=recipe&location=mypack:* for packs that add recipes in a namespace. * matches any run of characters,
+// and a location without a namespace is in minecraft, like in the game. Each project comes with the resources
+// that matched. The latest version is the latest release, unless ?channel=beta or ?channel=alpha lets in less
+// stable ones. It accepts the same filters and sorts as listProjects.
+func searchContents(c echo.Context) error {
+	// Read the patterns to match resources against.
+	location, err := getContentPattern(c, "location")
+	if err != nil {
+		return err
+	}
+	if location == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "missing location")
+	}
+	if !strings.Contains(location, ":") {
+		location = "minecraft:" + location
+	}
+
+	kind, err := getContentPattern(c, "kind")
+	if err != nil {
+		return err
+	}
 
-	// Handle errors during the search result collection.
+	channels, err := getChannelParam(c)
 	if err != nil {
-		log.Errorf("failed to collect search: %v\n", err.Error())
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to collect search")
+		return err
+	}
+
+	var query = db.ContentQuery{Kind: kind, Location: location, Channels: channels}
+
+	// Parse the query parameters for sorting, filtering and pagination.
+	sort, sortErr := getProjectSort(c, nil)
+	if sortErr != nil {
+		return sortErr
+	}
+	filter := getProjectFilter(c)
+
+	req, paginationErr := paging.GetPageRequest(c, projectListScope("contents", sort, filter, kind, location, strings.Join(channels, ",")))
+	if paginationErr != nil {
+		return paginationErr
+	}
+
+	// Establish a connection to the database.
+	var conn = db.EstablishConnection()
+
+	// Keep track of the start time of the search.
+	var startTime = time.Now()
+
+	// Search the indexed contents.
+	results, err := conn.SearchProjectContents(query, filter, sort, req)
+
+	if err != nil {
+		log.Errorf("failed to search contents: %v\n", err.Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to search")
+	}
+
+	// Count every match, for pagination.
+	total, err := conn.CountProjectContents(query, filter)
+	if err != nil {
+		log.Errorf("failed to count content search: %v\n", err.Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to search")
 	}
 
 	// Return the search results.
-	return c.JSON(http.StatusOK, SearchResults{
-		Time:    time.Since(startTime).Seconds(),
-		Count:   len(results),
-		Results: results,
-	})
+	var page = paging.NewPage(req, results, startTime)
+	page.Total = &total
+	return c.JSON(http.StatusOK, page)
+}
+
+const (
+	DefaultSuggestions = 5
+	MaxSuggestions     = 10
+)
+
+// suggest autocompletes project titles, meant to be called as the user types.
+func suggest(c echo.Context) error {
+	var query = strings.TrimSpace(c.QueryParam("q"))
+	if query == "" {
+		return c.JSON(http.StatusOK, []db.Suggestion{})
+	}
+
+	limit, err := strconv.Atoi(c.QueryParam("limit"))
+	if err != nil {
+		limit = DefaultSuggestions
+	}
+	if limit <= 0 || limit > MaxSuggestions {
+		return echo.NewHTTPError(http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(MaxSuggestions))
+	}
+
+	var conn = db.EstablishConnection()
+
+	suggestions, err := conn.SuggestProjects(query, limit)
+	if err != nil {
+		log.Errorf("failed to suggest: %v\n", err.Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to suggest")
+	}
+
+	// the same prefixes get typed over and over, let clients and proxies reuse answers for a bit
+	c.Response().Header().Set(echo.HeaderCacheControl, "public, max-age=60")
+	return c.JSON(http.StatusOK, suggestions)
 }
 
 func publishProject(c echo.Context) error {
@@ -722,11 +937,15 @@ func RegisterProjectRoutes(e *echo.Echo) {
 	e.GET("/projects/slug/:slug", getProjectBySlug, utils.DevRateLimiter(100))
 	e.GET("/projects/search/full", ftsSearch)
 	e.GET("/projects/search", search)
+	e.GET("/projects/search/contents", searchContents, utils.DevRateLimiter(10))
+	e.GET("/projects/search/suggest", suggest, utils.DevRateLimiter(100))
 	e.GET("/projects/featured", featuredProjects, utils.DevRateLimiter(100))
 
 	e.PUT("/projects/:id/publish", publishProject, utils.DevRateLimiter(100))
 	e.PUT("/projects/:id/draft", draftProject, utils.DevRateLimiter(100))
 	e.PUT("/projects/:id", updateProject, utils.DevRateLimiter(10))
+	e.GET("/projects/:id/stats", getProjectStats, utils.DevRateLimiter(10))
+	e.GET("/projects/:id/changelog", getProjectChangelog, utils.DevRateLimiter(10))
 
 	e.POST("/projects/create", createProject, utils.DevRateLimiter(10))
 
@@ -741,3 +960,13 @@ func IsUserProjectOwner(c echo.Context, project db.Project) (bool, error) {
 
 	return project.Author == user.ID, nil
 }
+
+// IsUserProjectManager reports whether the user may manage the project's versions: its owner, a moderator or an admin.
+func IsUserProjectManager(c echo.Context, project db.Project) (bool, error) {
+	user, contextError := auth.GetContextUser(c)
+	if contextError != nil {
+		return false, echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	return project.Author == user.ID || user.Role == auth.AdminRole || user.Role == auth.ModeratorRole, nil
+}
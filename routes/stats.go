@@ -0,0 +1,214 @@
+package routes
+
+import (
+	"encoding/csv"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/HoodieRocks/dph-api-2/utils/db"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+)
+
+const (
+	DefaultStatsDays = 30
+	MaxStatsDays     = 366
+)
+
+const statsDayFormat = "2006-01-02"
+
+// DayDownloads is the number of downloads on one day.
+type DayDownloads struct {
+	Day       string `json:"day"`
+	Downloads int    `json:"downloads"`
+}
+
+// VersionDownloads is the per-day series of one version.
+type VersionDownloads struct {
+	Version     string         `json:"version"`
+	VersionCode *string        `json:"version_code"` // nil once the version is deleted
+	Total       int            `json:"total"`
+	Days        []DayDownloads `json:"days"`
+}
+
+// ProjectStats are the downloads of a project over a range of days, every day in the range is listed.
+type ProjectStats struct {
+	From      string             `json:"from"`
+	To        string             `json:"to"`
+	Total     int                `json:"total"`
+	Days      []DayDownloads     `json:"days"`
+	Versions  []VersionDownloads `json:"versions"`
+	Clients   map[string]int     `json:"clients"`
+	Referrers map[string]int     `json:"referrers"`
+}
+
+// getStatsRange reads ?from= and ?to= as YYYY-MM-DD days in UTC, defaulting to the last 30 days.
+func getStatsRange(c echo.Context) (time.Time, time.Time, error) {
+	var to = time.Now().UTC().Truncate(24 * time.Hour)
+
+	if raw := c.QueryParam("to"); raw != "" {
+		parsed, err := time.Parse(statsDayFormat, raw)
+		if err != nil {
+			return time.Time{}, time.Time{}, echo.NewHTTPError(http.StatusBadRequest, "to must be a date like 2024-01-31")
+		}
+		to = parsed
+	}
+
+	var from = to.AddDate(0, 0, -(DefaultStatsDays - 1))
+
+	if raw := c.QueryParam("from"); raw != "" {
+		parsed, err := time.Parse(statsDayFormat, raw)
+		if err != nil {
+			return time.Time{}, time.Time{}, echo.NewHTTPError(http.StatusBadRequest, "from must be a date like 2024-01-01")
+		}
+		from = parsed
+	}
+
+	if from.After(to) {
+		return time.Time{}, time.Time{}, echo.NewHTTPError(http.StatusBadRequest, "from must not be after to")
+	}
+
+	if to.Sub(from) >= MaxStatsDays*24*time.Hour {
+		return time.Time{}, time.Time{}, echo.NewHTTPError(http.StatusBadRequest, "the range can be at most 366 days")
+	}
+
+	return from, to, nil
+}
+
+// emptyDays lists every day from from to to with no downloads.
+func emptyDays(from time.Time, to time.Time) []DayDownloads {
+	var days []DayDownloads
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		days = append(days, DayDownloads{Day: day.Format(statsDayFormat)})
+	}
+	return days
+}
+
+// buildProjectStats sums the daily rows up into the series of the whole project and of each version.
+func buildProjectStats(rows []db.DailyDownloads, from time.Time, to time.Time) ProjectStats {
+	var stats = ProjectStats{
+		From:      from.Format(statsDayFormat),
+		To:        to.Format(statsDayFormat),
+		Days:      emptyDays(from, to),
+		Versions:  []VersionDownloads{},
+		Clients:   map[string]int{},
+		Referrers: map[string]int{},
+	}
+
+	var versionIdx = map[string]int{}
+
+	for _, row := range rows {
+		var day = int(row.Day.Sub(from).Hours() / 24)
+		if day < 0 || day >= len(stats.Days) {
+			continue
+		}
+
+		idx, ok := versionIdx[row.Version]
+		if !ok {
+			idx = len(stats.Versions)
+			versionIdx[row.Version] = idx
+			stats.Versions = append(stats.Versions, VersionDownloads{
+				Version:     row.Version,
+				VersionCode: row.VersionCode,
+				Days:        emptyDays(from, to),
+			})
+		}
+
+		stats.Total += row.Downloads
+		stats.Days[day].Downloads += row.Downloads
+		stats.Versions[idx].Total += row.Downloads
+		stats.Versions[idx].Days[day].Downloads += row.Downloads
+		stats.Clients[row.Client] += row.Downloads
+		stats.Referrers[row.Referrer] += row.Downloads
+	}
+
+	return stats
+}
+
+// writeStatsCSV sends the daily rows as a CSV file, one line per day, version, client and referrer.
+func writeStatsCSV(c echo.Context, project db.Project, rows []db.DailyDownloads, from time.Time, to time.Time) error {
+	var name = project.Slug + "-downloads-" + from.Format(statsDayFormat) + "-" + to.Format(statsDayFormat) + ".csv"
+
+	c.Response().Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+	c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="`+name+`"`)
+	c.Response().WriteHeader(http.StatusOK)
+
+	var writer = csv.NewWriter(c.Response())
+
+	writer.Write([]string{"day", "version", "version_code", "client", "referrer", "downloads"})
+
+	for _, row := range rows {
+		var versionCode = ""
+		if row.VersionCode != nil {
+			versionCode = *row.VersionCode
+		}
+
+		writer.Write([]string{
+			row.Day.Format(statsDayFormat),
+			row.Version,
+			versionCode,
+			row.Client,
+			row.Referrer,
+			strconv.Itoa(row.Downloads),
+		})
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// getProjectStats returns the download stats of a project over ?from= to ?to=, per day and per version,
+// along with totals per client type and referrer type. ?format=csv exports the daily rows instead.
+// Stats are rolled up periodically, so the latest downloads may take a few minutes to show up.
+// It can be used by the project owner, moderators and admins.
+func getProjectStats(c echo.Context) error {
+	// Establish a connection to the database.
+	var conn = db.EstablishConnection()
+
+	// Get the project from the database.
+	project, err := conn.GetProjectByID(c.Param("id"))
+
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return echo.NewHTTPError(http.StatusNotFound, "no project with that id found")
+		}
+
+		log.Errorf("failed to fetch project: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch project")
+	}
+
+	// Check the user may see the stats.
+	canManage, err := IsUserProjectManager(c, project)
+
+	if err != nil {
+		return err
+	}
+
+	if !canManage {
+		return echo.NewHTTPError(http.StatusForbidden, "you can not view other's stats")
+	}
+
+	// Parse the range.
+	from, to, err := getStatsRange(c)
+
+	if err != nil {
+		return err
+	}
+
+	// Get the daily stats from the database.
+	rows, err := conn.GetDownloadStats(project.ID, from, to)
+
+	if err != nil {
+		log.Errorf("failed to fetch stats: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch stats")
+	}
+
+	if c.QueryParam("format") == "csv" {
+		return writeStatsCSV(c, project, rows, from, to)
+	}
+
+	return c.JSON(http.StatusOK, buildProjectStats(rows, from, to))
+}
@@ -0,0 +1,237 @@
+package routes
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/HoodieRocks/dph-api-2/utils/db"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+)
+
+// MaxUpdateChecks caps how many installed files a single update check may ask about.
+const MaxUpdateChecks = 100
+
+// InstalledFile identifies a version someone has installed, either by the hash of its file,
+// or by its project (id or slug) and version_code.
+type InstalledFile struct {
+	Algorithm   string `json:"algorithm,omitempty"`
+	Hash        string `json:"hash,omitempty"`
+	Project     string `json:"project,omitempty"`
+	VersionCode string `json:"version_code,omitempty"`
+}
+
+// UpdateCheckRequest is the body of an update check.
+type UpdateCheckRequest struct {
+	GameVersion string          `json:"game_version"`
+	Channel     string          `json:"channel"`
+	Files       []InstalledFile `json:"files"`
+}
+
+// VersionChangelog is the changelog of one version, raw and rendered to sanitized HTML.
+type VersionChangelog struct {
+	ID            string    `json:"id"`
+	VersionCode   string    `json:"version_code"`
+	Channel       string    `json:"channel"`
+	Yanked        bool      `json:"yanked"`
+	Creation      time.Time `json:"creation"`
+	Changelog     string    `json:"changelog"`
+	ChangelogHTML string    `json:"changelog_html"`
+}
+
+// newVersionChangelog picks the changelog of a version out of it.
+func newVersionChangelog(version db.Version) VersionChangelog {
+	return VersionChangelog{
+		ID:            version.ID,
+		VersionCode:   version.VersionCode,
+		Channel:       version.Channel,
+		Yanked:        version.Yanked,
+		Creation:      version.Creation,
+		Changelog:     version.Changelog,
+		ChangelogHTML: version.ChangelogHTML,
+	}
+}
+
+// UpdateCheck is the answer for one installed file, in the same position as the file in the request.
+type UpdateCheck struct {
+	Installed  *db.Version        `json:"installed"`
+	Update     *db.Version        `json:"update"` // nil when up to date, or nothing newer supports the game version
+	Changelogs []VersionChangelog `json:"changelogs"`
+	Error      string             `json:"error,omitempty"`
+}
+
+// checkUpdates takes a list of installed files, a Minecraft version and a release channel, and finds the
+// newest version of each that supports that Minecraft version and is at least as stable as the channel.
+// Files that can't be resolved get an error in their place instead of failing the whole request.
+// If the request is malformed or the Minecraft version is unknown, it returns a 400 error.
+func checkUpdates(c echo.Context) error {
+	// Parse the request body.
+	var body UpdateCheckRequest
+
+	if err := c.Bind(&body); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+
+	// Check the number of files.
+	if len(body.Files) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "files are required")
+	}
+
+	if len(body.Files) > MaxUpdateChecks {
+		return echo.NewHTTPError(http.StatusBadRequest, "too many files, the limit is 100")
+	}
+
+	// Check the release channel, release by default.
+	if body.Channel == "" {
+		body.Channel = db.ReleaseChannel
+	}
+
+	channels, ok := db.ChannelsUpTo(body.Channel)
+
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, "channel must be one of: "+strings.Join(db.VERSION_CHANNELS, ", "))
+	}
+
+	// Establish a connection to the database.
+	var conn = db.EstablishConnection()
+
+	// Check the Minecraft version exists.
+	if body.GameVersion == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "game_version is required")
+	}
+
+	if _, err := conn.GetGameVersion(body.GameVersion); err != nil {
+		if err == pgx.ErrNoRows {
+			return echo.NewHTTPError(http.StatusBadRequest, "unknown minecraft version")
+		}
+
+		log.Errorf("failed to fetch minecraft version: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch minecraft version")
+	}
+
+	// Answer every file in turn.
+	var checks = make([]UpdateCheck, 0, len(body.Files))
+
+	for _, file := range body.Files {
+		check, err := checkUpdate(file, body.GameVersion, channels)
+
+		if err != nil {
+			log.Errorf("failed to check for updates: %v\n", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to check for updates")
+		}
+
+		checks = append(checks, check)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"updates": checks})
+}
+
+// checkUpdate answers the update check for one installed file. Only database failures are returned as errors,
+// a file that can't be resolved is reported in the result.
+func checkUpdate(file InstalledFile, gameVersion string, channels []string) (UpdateCheck, error) {
+	var conn = db.EstablishConnection()
+	var check = UpdateCheck{Changelogs: []VersionChangelog{}}
+
+	// Find the installed version.
+	installed, problem, err := resolveInstalledFile(file)
+
+	if err != nil || problem != "" {
+		check.Error = problem
+		return check, err
+	}
+
+	check.Installed = installed
+
+	// Find the newest version that would work on the game version.
+	latest, err := conn.GetLatestVersion(installed.Project, channels, gameVersion)
+
+	if err == pgx.ErrNoRows {
+		return check, nil
+	}
+
+	if err != nil {
+		return check, err
+	}
+
+	// Nothing to do if the installed version is that version or newer.
+	if !latest.RanksAfter(*installed) {
+		return check, nil
+	}
+
+	check.Update = latest
+
+	// Clients need the files of the update to download it.
+	if err = loadVersionFiles(latest); err != nil {
+		return check, err
+	}
+
+	// Collect what changed on the way there.
+	between, err := conn.ListVersionsBetween(installed.Project, *installed, *latest, channels)
+
+	if err != nil {
+		return check, err
+	}
+
+	for _, version := range between {
+		check.Changelogs = append(check.Changelogs, newVersionChangelog(version))
+	}
+
+	return check, nil
+}
+
+// resolveInstalledFile finds the version of a live project an installed file refers to.
+// It returns a problem instead of a version when the file doesn't identify one.
+func resolveInstalledFile(file InstalledFile) (*db.Version, string, error) {
+	var conn = db.EstablishConnection()
+
+	// By hash.
+	if file.Hash != "" {
+		algo, hashes, err := normalizeHashes(file.Algorithm, []string{file.Hash})
+
+		if err != nil {
+			return nil, "invalid algorithm or hash", nil
+		}
+
+		matches, err := conn.GetVersionsByHash(algo, hashes)
+
+		if err != nil || len(matches) == 0 {
+			return nil, "no version with that hash found", err
+		}
+
+		return &matches[0].Version, "", nil
+	}
+
+	// By project and version_code.
+	if file.Project == "" || file.VersionCode == "" {
+		return nil, "either hash, or project and version_code are required", nil
+	}
+
+	project, err := conn.GetProjectByID(file.Project)
+
+	if err == pgx.ErrNoRows {
+		project, err = conn.GetProjectBySlug(file.Project)
+	}
+
+	if err == pgx.ErrNoRows || (err == nil && project.Status != StatusLive) {
+		return nil, "no project with that id found", nil
+	}
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	version, err := conn.GetVersionByRef(project.ID, file.VersionCode)
+
+	if err == pgx.ErrNoRows {
+		return nil, "no version with that version_code found", nil
+	}
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	return version, "", nil
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/HoodieRocks/dph-api-2/utils"
 	"github.com/HoodieRocks/dph-api-2/utils/db"
+	"github.com/HoodieRocks/dph-api-2/utils/paging"
 
 	"github.com/alexedwards/argon2id"
 	"github.com/jackc/pgx/v5"
@@ -78,7 +79,7 @@ func createUser(c echo.Context) error {
 		Bio:      "A new user!",
 		JoinDate: time.Now(),
 		Password: passHash,
-		Token:    auth.GenerateSecureToken(),
+		Token:    utils.GenerateSecureToken(),
 	}
 
 	// Begin a transaction.
@@ -175,18 +176,19 @@ func getProjectsByUser(c echo.Context) error {
 		id = user.ID
 	}
 
-	projects, err := conn.GetAllProjectsByAuthor(id)
+	req, err := paging.GetPageRequest(c, paging.Scope("user-projects", id))
+	if err != nil {
+		return err
+	}
+
+	var startTime = time.Now()
+	projects, err := conn.GetProjectsByAuthor(id, req)
 
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch projects")
 	}
 
-	return c.JSON(http.StatusOK, projects)
-}
-
-type StaffResponse struct {
-	Count int       `json:"count"`
-	Users []db.User `json:"users"`
+	return c.JSON(http.StatusOK, paging.NewPage(req, projects, startTime))
 }
 
 func getStaff(c echo.Context) error {
@@ -201,17 +203,20 @@ func getStaff(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "invalid role")
 	}
 
-	staff, err := conn.GetAllInRole(role)
+	req, err := paging.GetPageRequest(c, paging.Scope("staff", role))
+	if err != nil {
+		return err
+	}
+
+	var startTime = time.Now()
+	staff, err := conn.GetAllInRole(role, req)
 
 	if err != nil {
 		log.Errorf("failed to fetch staff: %v\n", err)
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch staff")
 	}
 
-	return c.JSON(http.StatusOK, StaffResponse{
-		Count: len(staff),
-		Users: staff,
-	})
+	return c.JSON(http.StatusOK, paging.NewPage(req, staff, startTime))
 }
 
 func RegisterUserRoutes(e *echo.Echo) {
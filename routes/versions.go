@@ -2,50 +2,110 @@ package routes
 
 import (
 	"context"
+	"errors"
 	"github.com/HoodieRocks/dph-api-2/auth"
+	"mime/multipart"
 	"net/http"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	derrors "github.com/HoodieRocks/dph-api-2/errors"
 	"github.com/HoodieRocks/dph-api-2/utils"
+	"github.com/HoodieRocks/dph-api-2/utils/datapack"
 	"github.com/HoodieRocks/dph-api-2/utils/db"
 	files "github.com/HoodieRocks/dph-api-2/utils/files"
+	"github.com/HoodieRocks/dph-api-2/utils/paging"
+	"github.com/HoodieRocks/dph-api-2/utils/stats"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/gommon/log"
 )
 
+const (
+	MaxVersionTitleLength       = 50
+	MaxVersionDescriptionLength = 2000
+	MaxChangelogLength          = 20000
+	MaxAddonFiles               = 8
+	MaxFileLabelLength          = 100
+)
+
+// resolveVersion looks up the version of a project named by the :vid parameter, either its id, its
+// version_code, or "latest" for the highest version that hasn't been yanked. latest only considers release
+// versions, unless ?channel=beta or ?channel=alpha lets in less stable ones, and ?game_version=1.20.4
+// narrows it down to versions that support that Minecraft version.
+// It returns a 404 error if there is no such version.
+func resolveVersion(c echo.Context, project db.Project) (*db.Version, error) {
+	var conn = db.EstablishConnection()
+	var ref = c.Param("vid")
+
+	var version *db.Version
+	var err error
+
+	if ref == "latest" {
+		channels, channelErr := getChannelParam(c)
+
+		if channelErr != nil {
+			return nil, channelErr
+		}
+
+		version, err = conn.GetLatestVersion(project.ID, channels, c.QueryParam("game_version"))
+	} else {
+		version, err = conn.GetVersionByRef(project.ID, ref)
+	}
+
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, echo.NewHTTPError(http.StatusNotFound, "no version found")
+		}
+
+		log.Errorf("failed to fetch version: %v\n", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch version")
+	}
+
+	if err = loadVersionFiles(version); err != nil {
+		log.Errorf("failed to fetch version files: %v\n", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch version")
+	}
+
+	return version, nil
+}
+
+// loadVersionFiles fills in the files of versions, in one query for all of them.
+func loadVersionFiles(versions ...*db.Version) error {
+	var ids = make([]string, 0, len(versions))
+	for _, version := range versions {
+		ids = append(ids, version.ID)
+	}
+
+	byVersion, err := db.EstablishConnection().GetVersionFiles(ids)
+
+	if err != nil {
+		return err
+	}
+
+	for _, version := range versions {
+		version.Files = byVersion[version.ID]
+	}
+
+	return nil
+}
+
 // getVersionOnProject retrieves a version of a project from the database.
 // It checks if the version exists and if the user has permission to access it.
-// The version is identified by the project ID and the index of its creation.
+// The version is identified by the project ID and the version's id, version_code or "latest", see resolveVersion.
 // If the version is not found, it returns a 404 error.
 // If the project is not found, it returns a 404 error.
-// If the version ID is not a number or "latest", it returns a 400 error.
 // If the token is invalid or expired, it returns a 403 error.
 // If the user does not have permission to access the project, it returns a 403 error.
 // If the project is in an illegal state, it returns a 500 error.
 // It returns the version as JSON if the project is live, or if the user is the project owner.
 func getVersionOnProject(c echo.Context) error {
-	// Extract the project ID and the index of the version from the parameters.
+	// Extract the project ID from the parameters.
 	pid := c.Param("pid")
-	rawIdx := c.Param("idx")
-
-	// Convert the index to an integer.
-	idx, err := strconv.Atoi(rawIdx)
-
-	// If the index is not "latest" and cannot be converted to an integer, return a 400 error.
-	if rawIdx != "latest" && err != nil {
-		log.Errorf("failed to fetch version: %v\n", err)
-		return echo.NewHTTPError(http.StatusBadRequest, "version ID must be a number or latest")
-	}
-
-	// If the index is "latest", set it to 0.
-	if rawIdx == "latest" {
-		idx = 0
-	}
 
 	// Establish a connection to the database.
 	var conn = db.EstablishConnection()
@@ -64,16 +124,10 @@ func getVersionOnProject(c echo.Context) error {
 	}
 
 	// Retrieve the version from the database.
-	version, err := conn.GetVersionByCreation(pid, idx)
+	version, err := resolveVersion(c, project)
 
-	// If the version is not found or could not be retrieved, return a 404 error.
-	if err != nil || version == nil {
-		if err == pgx.ErrNoRows {
-			return echo.NewHTTPError(http.StatusNotFound, "no version found")
-		}
-
-		log.Errorf("failed to fetch version: %v\n", err)
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch version")
+	if err != nil {
+		return err
 	}
 
 	// Check if the project is live or if the project is in draft mode, then check if the user is the project owner.
@@ -106,7 +160,12 @@ func getVersionOnProject(c echo.Context) error {
 // createVersion handles the creation of a new version for a project.
 // It expects an authorization token in the request header, as well as
 // form values for the title, description, version_code, supports, and
-// download files. It returns a JSON representation of the created version
+// download files. The download must contain a valid pack.mcmeta, and when
+// supports is left blank it is filled in from the pack format. Besides the
+// download and the rp_download resource pack, up to MaxAddonFiles optional
+// add-on archives can be sent as addon files, each optionally described by
+// an addon_label value in the same position.
+// It returns a JSON representation of the created version
 // or an error if any occurred.
 func createVersion(c echo.Context) error {
 
@@ -115,9 +174,30 @@ func createVersion(c echo.Context) error {
 	var title = c.FormValue("title")
 	var description = c.FormValue("description")
 	var versionCode = c.FormValue("version_code")
-	var supports = strings.Split(c.FormValue("supports"), ",")
+	var supports = splitList(c.FormValue("supports"))
+	var changelog = c.FormValue("changelog")
+	var channel = c.FormValue("channel")
 	download, err := c.FormFile("download")
 
+	// If the changelog is too long, return a 400 error.
+	if len(changelog) > MaxChangelogLength {
+		return echo.NewHTTPError(http.StatusBadRequest, "changelog is too long")
+	}
+
+	// Versions are addressed by their version_code, so it has to be there.
+	if versionCode == "" || versionCode == "latest" {
+		return echo.NewHTTPError(http.StatusBadRequest, "version_code is required and can't be latest")
+	}
+
+	// Versions are releases unless they say otherwise.
+	if channel == "" {
+		channel = db.ReleaseChannel
+	}
+
+	if !slices.Contains(db.VERSION_CHANNELS, channel) {
+		return echo.NewHTTPError(http.StatusBadRequest, "channel must be one of: "+strings.Join(db.VERSION_CHANNELS, ", "))
+	}
+
 	// If the download file is missing, return a 400 error.
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "download file is required")
@@ -126,6 +206,28 @@ func createVersion(c echo.Context) error {
 	// Retrieve the resource pack file from the request.
 	rpDownload, _ := c.FormFile("rp_download")
 
+	// Retrieve the optional add-on files and their labels from the request.
+	var addons []*multipart.FileHeader
+	var addonLabels []string
+	if form, err := c.MultipartForm(); err == nil {
+		addons = form.File["addon"]
+		addonLabels = form.Value["addon_label"]
+	}
+
+	if len(addons) > MaxAddonFiles {
+		return echo.NewHTTPError(http.StatusBadRequest, "too many addon files, the limit is "+strconv.Itoa(MaxAddonFiles))
+	}
+
+	if len(addonLabels) > len(addons) {
+		return echo.NewHTTPError(http.StatusBadRequest, "there are more addon labels than addon files")
+	}
+
+	for _, label := range addonLabels {
+		if len(label) > MaxFileLabelLength {
+			return echo.NewHTTPError(http.StatusBadRequest, "addon label is too long, the limit is "+strconv.Itoa(MaxFileLabelLength))
+		}
+	}
+
 	// Establish a database connection.
 	conn := db.EstablishConnection()
 
@@ -156,8 +258,22 @@ func createVersion(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusForbidden, "you can not access other's private projects")
 	}
 
-	// Upload the version file to the server.
-	downloadLink, err := files.UploadVersionFile(download, project)
+	// Check the version_code isn't taken before anything is uploaded, the unique index has the final say.
+	taken, err := conn.VersionCodeExists(project.ID, versionCode)
+
+	if err != nil {
+		log.Errorf("failed to check version code: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to check version code")
+	}
+
+	if taken {
+		return echo.NewHTTPError(http.StatusConflict, "a version with that version_code already exists")
+	}
+
+	// Upload the version file to the server, this also reads its pack.mcmeta and validates it.
+	stored, inspection, err := files.UploadVersionFile(download, project)
+	var downloadLink = stored.Link
+	var meta = inspection.Meta
 
 	// If the upload failed, return a 400 error.
 	if err != nil {
@@ -170,29 +286,96 @@ func createVersion(c echo.Context) error {
 			return echo.NewHTTPError(http.StatusBadRequest, "bad version file extension")
 		}
 
+		if derrors.IsArchiveError(err) {
+			return echo.NewHTTPError(http.StatusBadRequest, "version file rejected: "+err.Error())
+		}
+
+		if errors.Is(err, derrors.ErrMissingPackMeta) || errors.Is(err, derrors.ErrInvalidPackMeta) {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+
+		// Hand the whole report back, so authors can fix everything in one go.
+		var validationErr *datapack.ValidationError
+		if errors.As(err, &validationErr) {
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"message": "datapack failed validation",
+				"report":  validationErr.Report,
+			})
+		}
+
 		log.Errorf("failed to upload file: %v\n", err)
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to upload file")
 	}
 
+	// Load the Minecraft version registry to check the supported versions against.
+	gameVersions, err := conn.ListGameVersions("")
+
+	if err != nil {
+		log.Errorf("failed to fetch minecraft versions: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch minecraft versions")
+	}
+
+	// If the author didn't say which versions are supported, go by the pack format.
+	if len(supports) == 0 {
+		supports = datapack.GameVersionsFor(meta, gameVersions)
+	}
+
+	// Reject versions we don't know, and flag the ones the pack format can't actually load on.
+	unknown, warnings := datapack.CheckSupports(meta, supports, gameVersions)
+
+	if len(unknown) > 0 {
+		files.RemoveUpload(downloadLink)
+		return unknownGameVersionsError(unknown)
+	}
+
+	// Check the namespaces the pack uses against the ones other projects claimed.
+	var namespaces = inspection.Contents.ClaimableNamespaces()
+	blocked, namespaceWarnings, err := checkNamespaceClaims(project, namespaces)
+
+	if err != nil {
+		files.RemoveUpload(downloadLink)
+		log.Errorf("failed to check namespace claims: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to check namespace claims")
+	}
+
+	if len(blocked) > 0 {
+		files.RemoveUpload(downloadLink)
+		return echo.NewHTTPError(http.StatusConflict, "namespaces claimed by other projects: "+strings.Join(blocked, ", "))
+	}
+
+	warnings = append(warnings, namespaceWarnings...)
+
 	// Create a new version object.
 	var version = db.Version{
-		Title:        title,
-		Description:  description,
-		Creation:     time.Now(),
-		Downloads:    0,
-		DownloadLink: downloadLink,
-		Supports:     supports,
-		Project:      project.ID,
-		VersionCode:  versionCode,
+		Title:           title,
+		Description:     description,
+		Creation:        time.Now(),
+		Downloads:       0,
+		DownloadLink:    downloadLink,
+		Supports:        supports,
+		Project:         project.ID,
+		VersionCode:     versionCode,
+		PackFormat:      &meta.PackFormat,
+		MinFormat:       &meta.MinFormat,
+		MaxFormat:       &meta.MaxFormat,
+		PackDescription: &meta.Description,
+		Warnings:        append(inspection.Report.WarningStrings(), warnings...),
+		Hashes:          &stored.Hashes,
+		FileName:        &stored.FileName,
+		Channel:         channel,
+		Files:           []db.VersionFile{stored.VersionFile(db.PrimaryFile)},
 	}
 
+	version.SetChangelog(changelog)
+
 	// If a resource pack file was provided, upload it to the server.
 	if rpDownload != nil {
 
-		rpDownloadLink, err := files.UploadResourcePackFile(rpDownload, project)
+		rpStored, err := files.UploadResourcePackFile(rpDownload, project)
 
-		// If the upload failed, return a 400 error.
+		// If the upload failed, drop the datapack file again and return a 400 error.
 		if err != nil {
+			files.RemoveUpload(downloadLink)
 
 			if err == derrors.ErrFileTooLarge {
 				return echo.NewHTTPError(http.StatusBadRequest, "resource pack file is too big")
@@ -202,12 +385,52 @@ func createVersion(c echo.Context) error {
 				return echo.NewHTTPError(http.StatusBadRequest, "bad resource file extension")
 			}
 
+			if derrors.IsArchiveError(err) {
+				return echo.NewHTTPError(http.StatusBadRequest, "resource pack file rejected: "+err.Error())
+			}
+
 			log.Errorf("failed to upload file: %v\n", err)
 			return echo.NewHTTPError(http.StatusInternalServerError, "failed to upload file")
 		}
 
-		// Set the resource pack download link in the version object.
-		version.RpDownload = &rpDownloadLink
+		// Set the resource pack download link and hashes in the version object.
+		version.RpDownload = &rpStored.Link
+		version.RpHashes = &rpStored.Hashes
+		version.RpFileName = &rpStored.FileName
+		version.Files = append(version.Files, rpStored.VersionFile(db.ResourcePackFile))
+	}
+
+	// Upload the add-ons to the server.
+	for i, addon := range addons {
+
+		addonStored, err := files.UploadAddonFile(addon, project)
+
+		// If an upload failed, drop everything uploaded so far and return a 400 error.
+		if err != nil {
+			removeVersionUploads(version)
+
+			if err == derrors.ErrFileTooLarge {
+				return echo.NewHTTPError(http.StatusBadRequest, "addon file is too big")
+			}
+
+			if err == derrors.ErrFileBadExtension {
+				return echo.NewHTTPError(http.StatusBadRequest, "bad addon file extension")
+			}
+
+			if derrors.IsArchiveError(err) {
+				return echo.NewHTTPError(http.StatusBadRequest, "addon file rejected: "+err.Error())
+			}
+
+			log.Errorf("failed to upload file: %v\n", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to upload file")
+		}
+
+		var addonFile = addonStored.VersionFile(db.AddonFile)
+		if i < len(addonLabels) {
+			addonFile.Label = strings.TrimSpace(addonLabels[i])
+		}
+
+		version.Files = append(version.Files, addonFile)
 	}
 
 	// Start a transaction to create the version in the database.
@@ -225,17 +448,37 @@ func createVersion(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to create project")
 	}
 
-	// Create the version in the database.
-	err = conn.CreateVersion(tx, project.ID, version)
+	// Create the version, its files and its content index in the database.
+	version.ID, err = conn.CreateVersion(tx, project.ID, version)
+
+	if err == nil {
+		err = conn.CreateVersionFiles(tx, version.ID, version.Files)
+	}
+
+	if err == nil {
+		err = conn.CreateVersionContents(tx, version.ID, inspection.Contents)
+	}
 
-	// If the creation failed, rollback and return a 500 error.
+	// A live project claims the namespaces nobody has yet, other projects claim theirs when they go live.
+	if err == nil && project.Status == StatusLive {
+		err = conn.ClaimNamespaces(tx, project.ID, namespaces)
+	}
+
+	// If the creation failed, remove the uploads, rollback and return a 500 error.
 	if err != nil {
+		removeVersionUploads(version)
 		newErr := tx.Rollback(context.Background())
 
 		if newErr != nil {
 			log.Errorf("failed to rollback transaction: %v\n", newErr)
 			return echo.NewHTTPError(http.StatusInternalServerError, "failed to create project")
 		}
+
+		// Another upload took the version_code since it was checked.
+		if errors.Is(err, derrors.ErrVersionCodeTaken) {
+			return echo.NewHTTPError(http.StatusConflict, err.Error())
+		}
+
 		log.Errorf("failed to create version: %v\n", err)
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to create project")
 	}
@@ -243,8 +486,9 @@ func createVersion(c echo.Context) error {
 	// Commit the transaction.
 	err = tx.Commit(context.Background())
 
-	// If the commit failed, rollback and return a 500 error.
+	// If the commit failed, remove the uploads, rollback and return a 500 error.
 	if err != nil {
+		removeVersionUploads(version)
 		newErr := tx.Rollback(context.Background())
 
 		if newErr != nil {
@@ -259,8 +503,38 @@ func createVersion(c echo.Context) error {
 	return c.JSON(http.StatusCreated, version)
 }
 
-// listVersions returns all versions of a project. If the project is in a draft state,
-// it requires the user's token be valid and the owner of the project.
+// snapshotVersion matches the ids of snapshots, pre-releases and release candidates, e.g. 24w14a or 1.21-pre1.
+var snapshotVersion = regexp.MustCompile(`^\d\dw\d\d[a-z]$|-(pre|rc)\d*$|-snapshot-\d+$`)
+
+// unknownGameVersionsError is the 400 error for supports naming versions that aren't in the registry.
+// New snapshots are added by admins, so when one is named the error says so rather than hinting at a typo.
+func unknownGameVersionsError(unknown []string) error {
+	var message = "unknown minecraft versions: " + strings.Join(unknown, ", ")
+
+	if slices.ContainsFunc(unknown, snapshotVersion.MatchString) {
+		message += ", snapshots have to be added to the registry by an admin first"
+	}
+
+	return echo.NewHTTPError(http.StatusBadRequest, message)
+}
+
+// removeVersionUploads deletes the stored files of a version, e.g. one that could not be saved.
+func removeVersionUploads(version db.Version) {
+	files.RemoveUpload(version.DownloadLink)
+
+	if version.RpDownload != nil {
+		files.RemoveUpload(*version.RpDownload)
+	}
+
+	// the primary and resource pack files are removed above already, which is harmless
+	for _, file := range version.Files {
+		files.RemoveUpload(file.DownloadLink)
+	}
+}
+
+// listVersions returns a page of versions of a project, newest upload first or, with ?sort=version,
+// highest version_code first. ?channel= lists only the versions in those channels.
+// If the project is in a draft state, it requires the user's token be valid and the owner of the project.
 func listVersions(c echo.Context) error {
 	// Get the project ID from the request parameters.
 	pid := c.Param("pid")
@@ -282,20 +556,54 @@ func listVersions(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch version parent")
 	}
 
-	// Get all versions of the project from the database.
-	versions, err := conn.GetAllProjectVersions(pid)
+	// Parse the channel filter, e.g. ?channel=release,beta
+	var channels = splitList(c.QueryParam("channel"))
+	for _, channel := range channels {
+		if !slices.Contains(db.VERSION_CHANNELS, channel) {
+			return echo.NewHTTPError(http.StatusBadRequest, "channel must be one of: "+strings.Join(db.VERSION_CHANNELS, ", "))
+		}
+	}
+
+	// Parse the sort, newest upload or highest version_code first.
+	var sortName = c.QueryParam("sort")
+	if sortName == "" {
+		sortName = db.DefaultVersionSort
+	}
 
-	// If there were no versions found, return a 404 error.
+	sort, ok := db.VERSION_SORTS[sortName]
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, "sort must be newest or version")
+	}
+
+	// Parse the query parameters for pagination.
+	req, err := paging.GetPageRequest(c, paging.Scope("versions", pid, sortName, strings.Join(channels, ",")))
 	if err != nil {
-		if err == pgx.ErrNoRows {
-			return echo.NewHTTPError(http.StatusNotFound, "no version found")
-		}
+		return err
+	}
 
-		// If there was an error fetching the versions, return a 500 error.
+	// Get a page of versions of the project from the database.
+	var startTime = time.Now()
+	rows, err := conn.ListProjectVersions(pid, channels, sort, req)
+
+	// If there was an error fetching the versions, return a 500 error.
+	if err != nil {
 		log.Errorf("failed to fetch version: %v\n", err)
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch version")
 	}
 
+	// Fill in the files of the versions.
+	var listed = make([]*db.Version, 0, len(rows))
+	for i := range rows {
+		listed = append(listed, &rows[i].Version)
+	}
+
+	if err = loadVersionFiles(listed...); err != nil {
+		log.Errorf("failed to fetch version files: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch version")
+	}
+
+	var versions = paging.NewPage(req, rows, startTime)
+
 	// Check the status of the project.
 	switch project.Status {
 	case StatusLive:
@@ -322,7 +630,43 @@ func listVersions(c echo.Context) error {
 	}
 }
 
+// downloadVersion sends the datapack file of a version, see resolveVersion for how the version is picked.
+// Downloads of live projects are logged for the project's stats and counted on the version and the project,
+// repeat downloads by the same client within stats.DedupWindow only count once.
+// If the project is a draft or pending, only its owner may download it.
 func downloadVersion(c echo.Context) error {
+	project, version, err := getVisibleVersion(c)
+
+	if err != nil {
+		return err
+	}
+
+	return sendVersionFile(c, project, *version, version.MainFile())
+}
+
+// downloadVersionFile sends one of the files of a version by its id, e.g. its resource pack or an add-on.
+// Every file counts its own downloads, only downloads of the primary file count for the version and project.
+// The same access rules as downloadVersion apply.
+func downloadVersionFile(c echo.Context) error {
+	project, version, err := getVisibleVersion(c)
+
+	if err != nil {
+		return err
+	}
+
+	// Find the file on the version.
+	file, ok := version.FileByID(c.Param("fid"))
+
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "no file with that id found")
+	}
+
+	return sendVersionFile(c, project, *version, file)
+}
+
+// getVisibleVersion loads the project and version a request is for, and checks the user may see it:
+// anyone for live projects, only the owner for drafts and pending projects.
+func getVisibleVersion(c echo.Context) (db.Project, *db.Version, error) {
 	pid := c.Param("pid")
 
 	var conn = db.EstablishConnection()
@@ -332,90 +676,386 @@ func downloadVersion(c echo.Context) error {
 	if err != nil {
 
 		if err == pgx.ErrNoRows {
-			return echo.NewHTTPError(http.StatusNotFound, "no project with that id found")
+			return project, nil, echo.NewHTTPError(http.StatusNotFound, "no project with that id found")
 		}
 
 		log.Errorf("failed to fetch version parent: %v\n", err)
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch version parent")
+		return project, nil, echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch version parent")
+	}
+
+	// Check the status of the project.
+	switch project.Status {
+	case StatusLive:
+		// Anyone may download from live projects.
+	case StatusDraft, StatusPending:
+		// Check if the user is the owner of the project.
+		isOwner, err := IsUserProjectOwner(c, project)
+
+		if err != nil {
+			return project, nil, err
+		}
+
+		// If the user is not the owner, return a forbidden error.
+		if !isOwner {
+			return project, nil, echo.NewHTTPError(http.StatusForbidden, "you can not access other's private projects")
+		}
+	default:
+		// If the project is in an illegal state, return an internal server error.
+		return project, nil, echo.NewHTTPError(http.StatusInternalServerError, "illegal project state")
+	}
+
+	// Get the version from the database.
+	version, err := resolveVersion(c, project)
+
+	return project, version, err
+}
+
+// sendVersionFile sends a file of a version, and counts the download if the project is live.
+// Owners testing their own drafts don't count as downloads.
+func sendVersionFile(c echo.Context, project db.Project, version db.Version, file db.VersionFile) error {
+	// Yanked versions stay downloadable by direct link, but clients should know.
+	if version.Yanked {
+		c.Response().Header().Set("Warning", `299 - "this version has been yanked"`)
 	}
 
-	idx, err := strconv.Atoi(c.Param("vid"))
+	var hashes *db.FileHashes
+	if file.Hashes != (db.FileHashes{}) {
+		hashes = &file.Hashes
+	}
+
+	var fileName *string
+	if file.FileName != "" {
+		fileName = &file.FileName
+	}
+
+	// Send the file.
+	counted, err := serveDownload(c, file.DownloadLink, fileName, hashes)
+
+	if err != nil || !counted || project.Status != StatusLive {
+		return err
+	}
+
+	// Count the download once the file has been sent. It is buffered and written in a batch,
+	// where repeat downloads by the same client are only counted once.
+	event, err := stats.NewDownloadEvent(c.Request(), c.RealIP(), version, file)
+
 	if err != nil {
+		log.Errorf("failed to build download event: %v\n", err)
+		return nil
+	}
+
+	stats.Downloads.Add(event)
+
+	return nil
+}
+
+// getChannelParam reads the ?channel= a version must be at least as stable as, release by default.
+func getChannelParam(c echo.Context) ([]string, error) {
+	var channel = c.QueryParam("channel")
+
+	if channel == "" {
+		channel = db.ReleaseChannel
+	}
+
+	channels, ok := db.ChannelsUpTo(channel)
+
+	if !ok {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "channel must be one of: "+strings.Join(db.VERSION_CHANNELS, ", "))
+	}
+
+	return channels, nil
+}
+
+// getManagedVersion loads the project and version a management request is about,
+// and checks the user is the project owner, a moderator or an admin.
+func getManagedVersion(c echo.Context) (db.Project, *db.Version, error) {
+	var conn = db.EstablishConnection()
 
+	// Get the project from the database.
+	project, err := conn.GetProjectByID(c.Param("pid"))
+
+	if err != nil {
 		if err == pgx.ErrNoRows {
-			return echo.NewHTTPError(http.StatusNotFound, "no version found")
+			return project, nil, echo.NewHTTPError(http.StatusNotFound, "no project with that id found")
 		}
 
-		log.Errorf("failed to fetch version: %v\n", err)
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch version")
+		log.Errorf("failed to fetch version parent: %v\n", err)
+		return project, nil, echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch version parent")
+	}
+
+	// Check the user may manage the project.
+	canManage, err := IsUserProjectManager(c, project)
+
+	if err != nil {
+		return project, nil, err
+	}
+
+	if !canManage {
+		return project, nil, echo.NewHTTPError(http.StatusForbidden, "you can not manage other's versions")
 	}
 
 	// Get the version from the database.
-	version, err := conn.GetVersionByCreation(pid, idx)
+	version, err := resolveVersion(c, project)
+
+	return project, version, err
+}
+
+// formValue returns a form value and whether it was sent at all, so fields can be cleared by sending them empty.
+func formValue(c echo.Context, name string) (string, bool) {
+	form, err := c.FormParams()
 
-	// If there was an error fetching the version, return an appropriate error.
 	if err != nil {
-		if err == pgx.ErrNoRows {
-			return echo.NewHTTPError(http.StatusNotFound, "no version found")
+		return "", false
+	}
+
+	values, ok := form[name]
+
+	if !ok || len(values) == 0 {
+		return "", false
+	}
+
+	return values[0], true
+}
+
+// checkVersionSupports checks supports against the Minecraft version registry and the pack format of the
+// stored datapack, and returns the warnings to store on the version. Unknown versions are a 400 error.
+// When supports is empty, it is filled in from the pack format.
+func checkVersionSupports(version *db.Version, supports []string) ([]string, []string, error) {
+	// Load the Minecraft version registry to check the supported versions against.
+	gameVersions, err := db.EstablishConnection().ListGameVersions("")
+
+	if err != nil {
+		log.Errorf("failed to fetch minecraft versions: %v\n", err)
+		return nil, nil, echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch minecraft versions")
+	}
+
+	// Read the stored pack again, validation warnings are kept while the supports warnings are redone.
+	inspection, err := datapack.InspectFile(files.LocalPath(version.DownloadLink))
+
+	var validationErr *datapack.ValidationError
+	var inspected = err == nil || errors.As(err, &validationErr)
+
+	if len(supports) == 0 {
+		if !inspected {
+			return nil, nil, echo.NewHTTPError(http.StatusBadRequest, "supports is required")
 		}
-		log.Errorf("failed to fetch version: %v\n", err)
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch version")
+		supports = datapack.GameVersionsFor(inspection.Meta, gameVersions)
 	}
 
-	// Check the status of the project.
-	switch project.Status {
-	case StatusLive:
+	unknown, warnings := datapack.CheckSupports(inspection.Meta, supports, gameVersions)
+
+	if len(unknown) > 0 {
+		return nil, nil, unknownGameVersionsError(unknown)
+	}
+
+	// versions uploaded before packs were inspected have no format to check the claims against
+	if !inspected {
+		return supports, version.Warnings, nil
+	}
+
+	return supports, append(inspection.Report.WarningStrings(), warnings...), nil
+}
+
+// updateVersion edits the title, description, supports and changelog of a version. Only the fields
+// that are sent are changed. Supports are checked against the Minecraft version registry again.
+// It can be used by the project owner, moderators and admins, and bumps the project's updated time.
+func updateVersion(c echo.Context) error {
+	// Get the project and version, checking the user may edit them.
+	project, version, err := getManagedVersion(c)
+
+	if err != nil {
+		return err
+	}
+
+	// Apply the fields that were sent.
+	if title, ok := formValue(c, "title"); ok {
+		if title == "" || len(title) > MaxVersionTitleLength {
+			return echo.NewHTTPError(http.StatusBadRequest, "title must be between 1 and 50 characters")
+		}
+		version.Title = title
+	}
+
+	if description, ok := formValue(c, "description"); ok {
+		if len(description) > MaxVersionDescriptionLength {
+			return echo.NewHTTPError(http.StatusBadRequest, "description is too long")
+		}
+		version.Description = description
+	}
+
+	if changelog, ok := formValue(c, "changelog"); ok {
+		if len(changelog) > MaxChangelogLength {
+			return echo.NewHTTPError(http.StatusBadRequest, "changelog is too long")
+		}
+		version.SetChangelog(changelog)
+	}
+
+	if channel, ok := formValue(c, "channel"); ok {
+		if !slices.Contains(db.VERSION_CHANNELS, channel) {
+			return echo.NewHTTPError(http.StatusBadRequest, "channel must be one of: "+strings.Join(db.VERSION_CHANNELS, ", "))
+		}
+		version.Channel = channel
+	}
+
+	if rawSupports, ok := formValue(c, "supports"); ok {
+		version.Supports, version.Warnings, err = checkVersionSupports(version, splitList(rawSupports))
+
+		if err != nil {
+			return err
+		}
+	}
+
+	// Save the version and bump the project in a transaction.
+	var conn = db.EstablishConnection()
+	tx, err := conn.Db.Begin(context.Background())
+
+	if err != nil {
+		log.Errorf("failed to initialise transaction: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update version")
+	}
+
+	err = conn.UpdateVersion(tx, *version)
+
+	if err == nil {
+		err = conn.TouchProject(tx, project.ID)
+	}
+
+	// If the update failed, rollback and return a 500 error.
+	if err != nil {
+		newErr := tx.Rollback(context.Background())
+
+		if newErr != nil {
+			log.Errorf("failed to rollback transaction: %v\n", newErr)
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to update version")
+		}
+		log.Errorf("failed to update version: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update version")
+	}
+
+	// Commit the transaction.
+	err = tx.Commit(context.Background())
+
+	if err != nil {
+		log.Errorf("failed to commit transaction: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update version")
+	}
+
+	return c.JSON(http.StatusOK, version)
+}
+
+// setVersionYanked yanks or unyanks a version. A yanked version is skipped when resolving "latest",
+// but stays listed and downloadable by direct link, flagged with a warning.
+func setVersionYanked(yanked bool) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		// Get the project and version, checking the user may manage them.
+		project, version, err := getManagedVersion(c)
+
+		if err != nil {
+			return err
+		}
+
+		// Save the flag and bump the project in a transaction.
+		var conn = db.EstablishConnection()
 		tx, err := conn.Db.Begin(context.Background())
 
 		if err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction")
+			log.Errorf("failed to initialise transaction: %v\n", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to update version")
 		}
 
-		err = conn.UpdateProjectDownloads(tx, project.ID, project.Downloads+1)
+		err = conn.SetVersionYanked(tx, version.ID, yanked)
+
+		if err == nil {
+			err = conn.TouchProject(tx, project.ID)
+		}
 
+		// If the update failed, rollback and return a 500 error.
 		if err != nil {
 			newErr := tx.Rollback(context.Background())
 
 			if newErr != nil {
 				log.Errorf("failed to rollback transaction: %v\n", newErr)
-				return echo.NewHTTPError(http.StatusInternalServerError, "failed to create project")
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to update version")
 			}
-
-			log.Errorf("failed to update downloads: %v\n", err)
-			return echo.NewHTTPError(http.StatusInternalServerError, "failed to update project downloads")
+			log.Errorf("failed to yank version: %v\n", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to update version")
 		}
 
+		// Commit the transaction.
 		err = tx.Commit(context.Background())
 
 		if err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit transaction")
+			log.Errorf("failed to commit transaction: %v\n", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to update version")
 		}
 
-		return c.File(version.DownloadLink)
-	case StatusDraft, StatusPending:
-		// Check if the user is the owner of the project.
-		isOwner, err := IsUserProjectOwner(c, project)
+		version.Yanked = yanked
+		return c.JSON(http.StatusOK, version)
+	}
+}
 
-		if err != nil {
-			return err
-		}
+// deleteVersion removes a version for good, along with its stored files, and bumps the project's updated time.
+// It can be used by the project owner, moderators and admins.
+func deleteVersion(c echo.Context) error {
+	// Get the project and version, checking the user may delete them.
+	project, version, err := getManagedVersion(c)
 
-		// Check if the user is the owner of the project.
-		if isOwner {
-			return c.File(version.DownloadLink)
-		} else {
-			// If the user is not the owner, return a forbidden error.
-			return echo.NewHTTPError(http.StatusForbidden, "you can not access other's private projects")
+	if err != nil {
+		return err
+	}
+
+	// Delete the version and bump the project in a transaction.
+	var conn = db.EstablishConnection()
+	tx, err := conn.Db.Begin(context.Background())
+
+	if err != nil {
+		log.Errorf("failed to initialise transaction: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete version")
+	}
+
+	err = conn.DeleteVersion(tx, version.ID)
+
+	if err == nil {
+		err = conn.TouchProject(tx, project.ID)
+	}
+
+	// If the delete failed, rollback and return a 500 error.
+	if err != nil {
+		newErr := tx.Rollback(context.Background())
+
+		if newErr != nil {
+			log.Errorf("failed to rollback transaction: %v\n", newErr)
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete version")
 		}
-	default:
-		// If the project is in an illegal state, return an internal server error.
-		return echo.NewHTTPError(http.StatusInternalServerError, "illegal project state")
+		log.Errorf("failed to delete version: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete version")
+	}
+
+	// Commit the transaction.
+	err = tx.Commit(context.Background())
+
+	if err != nil {
+		log.Errorf("failed to commit transaction: %v\n", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete version")
 	}
+
+	// Only remove the files once the version is gone, so a failed delete never leaves it without them.
+	removeVersionUploads(*version)
+
+	return c.NoContent(http.StatusNoContent)
 }
 
 func RegisterVersionRoutes(e *echo.Echo) {
-	e.GET("/projects/:pid/versions/:idx", getVersionOnProject, utils.DevRateLimiter(10))
+	e.GET("/projects/:pid/versions/:vid", getVersionOnProject, utils.DevRateLimiter(10))
 	e.GET("/projects/:pid/versions", listVersions, utils.DevRateLimiter(10))
 	e.POST("/projects/:pid/versions/create", createVersion, utils.DevRateLimiter(10))
-	e.GET("/projects/:pid/versions/:idx/download", downloadVersion, utils.DevRateLimiter(10))
+	e.GET("/projects/:pid/versions/:vid/download", downloadVersion, utils.DevRateLimiter(10))
+	e.GET("/projects/:pid/versions/:vid/files/:fid/download", downloadVersionFile, utils.DevRateLimiter(10))
+	e.GET("/projects/:pid/versions/:vid/contents", getVersionContents, utils.DevRateLimiter(10))
+	e.PATCH("/projects/:pid/versions/:vid", updateVersion, utils.DevRateLimiter(10))
+	e.DELETE("/projects/:pid/versions/:vid", deleteVersion, utils.DevRateLimiter(10))
+	e.POST("/projects/:pid/versions/:vid/yank", setVersionYanked(true), utils.DevRateLimiter(10))
+	e.DELETE("/projects/:pid/versions/:vid/yank", setVersionYanked(false), utils.DevRateLimiter(10))
+	e.GET("/versions/by-hash/:algo/:hash", getVersionByHash, utils.DevRateLimiter(10))
+	e.POST("/versions/by-hash", getVersionsByHashes, utils.DevRateLimiter(10))
+	e.POST("/versions/updates", checkUpdates, utils.DevRateLimiter(10))
 }
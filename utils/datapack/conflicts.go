@@ -0,0 +1,226 @@
+package datapack
+
+import (
+	"archive/zip"
+	"bytes"
+	"cmp"
+	"crypto/sha256"
+	"io"
+	"slices"
+	"strings"
+)
+
+// Why packs that have the same resource do or don't get along.
+const (
+	ConflictOverride    = "override"    // only the file of the last pack is used
+	ConflictTagReplace  = "tag_replace" // a later pack's tag has replace set, dropping the entries of the packs before it
+	CompatibleTagMerge  = "tag_merge"   // the tags are merged
+	CompatibleIdentical = "identical"   // every pack has the same file
+)
+
+// Pack is one datapack of a load order.
+type Pack struct {
+	Archive *zip.Reader
+	Meta    PackMeta
+}
+
+// ResourceConflict is a resource several packs of a load order have.
+type ResourceConflict struct {
+	Kind     string `json:"kind"`
+	Location string `json:"location"`
+	Reason   string `json:"reason"`
+	Packs    []int  `json:"packs"`            // positions in the load order of the packs that have it
+	Winner   *int   `json:"winner,omitempty"` // position of the pack whose file is used, nil when nothing is lost
+}
+
+// SharedNamespace is a namespace several packs of a load order put files in. SharedNamespaces, which every
+// pack may extend, are left out.
+type SharedNamespace struct {
+	Namespace string `json:"namespace"`
+	Packs     []int  `json:"packs"`
+}
+
+// ConflictReport is what happens when a list of packs is loaded together.
+type ConflictReport struct {
+	Conflicts  []ResourceConflict `json:"conflicts"`
+	Compatible []ResourceConflict `json:"compatible"`
+	Namespaces []SharedNamespace  `json:"namespaces"`
+}
+
+// packFile is the file a pack has for a resource.
+type packFile struct {
+	pack int
+	file *zip.File
+}
+
+// PackResources maps every resource of a pack to its file. Resources are keyed without their overlay. The game
+// layers overlays over the base data/ folder in the order pack.mcmeta declares them, so the file of the last
+// declared overlay that has the resource is the one that is used.
+func PackResources(pack Pack) map[Resource]*zip.File {
+	var resources = map[Resource]*zip.File{}
+	var layers = map[Resource]int{}
+
+	for _, file := range pack.Archive.File {
+		overlay, resource, ok := packFileResource(pack, file)
+		if !ok {
+			continue
+		}
+
+		// data/ is layer 0, the overlays follow in the order they are declared
+		var layer = slices.Index(pack.Meta.Overlays, overlay) + 1
+		if seen, ok := layers[resource]; ok && seen >= layer {
+			continue
+		}
+
+		resources[resource] = file
+		layers[resource] = layer
+	}
+
+	return resources
+}
+
+// FindConflicts reports the resources several of packs have and the namespaces they share. Packs are
+// in load order, a pack's files override the ones of the packs before it.
+func FindConflicts(packs []Pack) (ConflictReport, error) {
+	var report = ConflictReport{Conflicts: []ResourceConflict{}, Compatible: []ResourceConflict{}, Namespaces: []SharedNamespace{}}
+	var holders = map[Resource][]packFile{}
+	var namespaces = map[string][]int{}
+
+	for i, pack := range packs {
+		var packNamespaces = map[string]bool{}
+
+		for resource, file := range PackResources(pack) {
+			holders[resource] = append(holders[resource], packFile{pack: i, file: file})
+			packNamespaces[resource.Namespace()] = true
+		}
+
+		for namespace := range packNamespaces {
+			namespaces[namespace] = append(namespaces[namespace], i)
+		}
+	}
+
+	for resource, files := range holders {
+		if len(files) < 2 {
+			continue
+		}
+
+		conflict, err := classifyConflict(resource, files)
+		if err != nil {
+			return report, err
+		}
+
+		if conflict.Reason == CompatibleTagMerge || conflict.Reason == CompatibleIdentical {
+			report.Compatible = append(report.Compatible, conflict)
+		} else {
+			report.Conflicts = append(report.Conflicts, conflict)
+		}
+	}
+
+	for namespace, users := range namespaces {
+		if len(users) > 1 && !slices.Contains(SharedNamespaces, namespace) {
+			report.Namespaces = append(report.Namespaces, SharedNamespace{Namespace: namespace, Packs: users})
+		}
+	}
+
+	var byResource = func(a, b ResourceConflict) int {
+		return cmp.Or(strings.Compare(a.Kind, b.Kind), strings.Compare(a.Location, b.Location))
+	}
+	slices.SortFunc(report.Conflicts, byResource)
+	slices.SortFunc(report.Compatible, byResource)
+	slices.SortFunc(report.Namespaces, func(a, b SharedNamespace) int {
+		return strings.Compare(a.Namespace, b.Namespace)
+	})
+
+	return report, nil
+}
+
+// classifyConflict works out what happens to a resource several packs have, files are in load order.
+func classifyConflict(resource Resource, files []packFile) (ResourceConflict, error) {
+	var conflict = ResourceConflict{Kind: resource.Kind, Location: resource.Location}
+	for _, file := range files {
+		conflict.Packs = append(conflict.Packs, file.pack)
+	}
+
+	identical, err := identicalFiles(files)
+	if err != nil {
+		return conflict, err
+	}
+
+	if identical {
+		conflict.Reason = CompatibleIdentical
+		return conflict, nil
+	}
+
+	if IsTagKind(resource.Kind) {
+		var replacing = -1
+		var readable = true
+
+		// the first pack replacing the tag only drops the vanilla entries
+		for i, file := range files {
+			tag, err := ReadTag(file.file)
+			if err != nil {
+				readable = false
+				break
+			}
+			if i > 0 && tag.Replace {
+				replacing = file.pack
+			}
+		}
+
+		if readable && replacing < 0 {
+			conflict.Reason = CompatibleTagMerge
+			return conflict, nil
+		}
+
+		if readable {
+			conflict.Reason = ConflictTagReplace
+			conflict.Winner = &replacing
+			return conflict, nil
+		}
+	}
+
+	// a broken tag can't be merged, the game just fails to load it
+	var last = files[len(files)-1].pack
+	conflict.Reason = ConflictOverride
+	conflict.Winner = &last
+	return conflict, nil
+}
+
+// identicalFiles reports whether every file has the same content.
+func identicalFiles(files []packFile) (bool, error) {
+	var first []byte
+
+	for i, file := range files {
+		if file.file.UncompressedSize64 != files[0].file.UncompressedSize64 {
+			return false, nil
+		}
+
+		sum, err := hashZipFile(file.file)
+		if err != nil {
+			return false, err
+		}
+
+		if i == 0 {
+			first = sum
+		} else if !bytes.Equal(sum, first) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func hashZipFile(file *zip.File) ([]byte, error) {
+	reader, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	var digest = sha256.New()
+	if _, err = io.Copy(digest, reader); err != nil {
+		return nil, err
+	}
+
+	return digest.Sum(nil), nil
+}
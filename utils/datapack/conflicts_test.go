@@ -0,0 +1,138 @@
+package datapack
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"testing"
+)
+
+// testMeta is a pack.mcmeta for formats 48 to 61.
+const testMeta = `{"pack":{"pack_format":48,"supported_formats":[48,61],"description":"test"}}`
+
+// testPack builds a pack in memory from name, content pairs, in that order.
+func testPack(t *testing.T, files ...string) Pack {
+	t.Helper()
+
+	var buffer bytes.Buffer
+	var archive = zip.NewWriter(&buffer)
+
+	for i := 0; i+1 < len(files); i += 2 {
+		writer, err := archive.Create(files[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = writer.Write([]byte(files[i+1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := zip.NewReader(bytes.NewReader(buffer.Bytes()), int64(buffer.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err := ReadPackMeta(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return Pack{Archive: reader, Meta: meta}
+}
+
+// readTestFile returns the content of file.
+func readTestFile(t *testing.T, file *zip.File) string {
+	t.Helper()
+
+	reader, err := file.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(data)
+}
+
+func TestPackResourcesPrefersOverlays(t *testing.T) {
+	var pack = testPack(t,
+		"pack.mcmeta", `{"pack":{"pack_format":48,"supported_formats":[48,61],"description":"test"},
+			"overlays":{"entries":[{"formats":[48,57],"directory":"old"},{"formats":[57,61],"directory":"new"}]}}`,
+		"new/data/x/loot_table/a.json", "new",
+		"data/x/loot_table/a.json", "base",
+		"old/data/x/loot_table/a.json", "old",
+		"old/data/x/loot_table/b.json", "old",
+		"data/x/loot_table/b.json", "base",
+		"data/x/loot_table/c.json", "base",
+		"undeclared/data/x/loot_table/c.json", "undeclared",
+	)
+
+	var want = map[string]string{"x:a": "new", "x:b": "old", "x:c": "base"}
+
+	var resources = PackResources(pack)
+	if len(resources) != len(want) {
+		t.Fatalf("expected %d resources, got %d", len(want), len(resources))
+	}
+
+	for resource, file := range resources {
+		if got := readTestFile(t, file); got != want[resource.Location] {
+			t.Errorf("%s: expected the %s file, got the %s one", resource.Location, want[resource.Location], got)
+		}
+	}
+}
+
+func TestFindConflicts(t *testing.T) {
+	var first = testPack(t,
+		"pack.mcmeta", testMeta,
+		"data/minecraft/tags/function/tick.json", `{"values":["a:tick"]}`,
+		"data/minecraft/loot_table/entities/zombie.json", `{"pools":[1]}`,
+		"data/c/tags/item/ores.json", `{"values":["a:ore"]}`,
+		"data/shared/recipe/same.json", `{"same":true}`,
+		"data/shared/tags/block/replaced.json", `{"values":["a:block"]}`,
+	)
+	var second = testPack(t,
+		"pack.mcmeta", testMeta,
+		"data/minecraft/tags/function/tick.json", `{"values":["b:tick"]}`,
+		"data/minecraft/loot_table/entities/zombie.json", `{"pools":[2]}`,
+		"data/c/tags/item/ores.json", `{"values":["b:ore"]}`,
+		"data/shared/recipe/same.json", `{"same":true}`,
+		"data/shared/tags/block/replaced.json", `{"replace":true,"values":["b:block"]}`,
+	)
+
+	report, err := FindConflicts([]Pack{first, second})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var reasons = map[string]string{}
+	for _, conflict := range append(report.Conflicts, report.Compatible...) {
+		reasons[conflict.Location] = conflict.Reason
+	}
+
+	var want = map[string]string{
+		"minecraft:tick":            CompatibleTagMerge,
+		"minecraft:entities/zombie": ConflictOverride,
+		"c:ores":                    CompatibleTagMerge,
+		"shared:same":               CompatibleIdentical,
+		"shared:replaced":           ConflictTagReplace,
+	}
+
+	for location, reason := range want {
+		if reasons[location] != reason {
+			t.Errorf("%s: expected %q, got %q", location, reason, reasons[location])
+		}
+	}
+
+	// minecraft and c are meant to be shared
+	if len(report.Namespaces) != 1 || report.Namespaces[0].Namespace != "shared" {
+		t.Errorf("expected only the shared namespace, got %v", report.Namespaces)
+	}
+}
@@ -0,0 +1,170 @@
+package datapack
+
+import (
+	"archive/zip"
+	"cmp"
+	"slices"
+	"strings"
+)
+
+// FunctionKind is the kind of .mcfunction files. Functions are only counted in Contents, a pack can
+// easily have thousands of them.
+const FunctionKind = "function"
+
+// Resource is one file a datapack adds or overrides, named the way the game names it.
+type Resource struct {
+	// Kind is the registry folder the file is in, with the names used since pack format 45,
+	// e.g. recipe, loot_table, tags/function or worldgen/biome
+	Kind string `json:"kind"`
+	// Location is the resource location, e.g. minecraft:entities/zombie
+	Location string `json:"location"`
+	// Overlay is the overlay folder the file is in, empty for the base data/ folder
+	Overlay string `json:"overlay,omitempty"`
+}
+
+// Namespace returns the namespace part of the resource location.
+func (resource Resource) Namespace() string {
+	namespace, _, _ := strings.Cut(resource.Location, ":")
+	return namespace
+}
+
+// NamespaceContents sums up what a datapack has in one namespace.
+type NamespaceContents struct {
+	Namespace string `json:"namespace"`
+	Functions int    `json:"functions"`
+	Resources int    `json:"resources"` // everything but functions
+}
+
+// Contents is an index of what a datapack contains.
+type Contents struct {
+	Namespaces []NamespaceContents `json:"namespaces"`
+	Resources  []Resource          `json:"resources"` // everything but functions
+}
+
+// ParseResource names the file at parts, a path in an archive split at / that starts at a data folder.
+// It returns false for files that aren't resources the game would load.
+func ParseResource(parts []string) (Resource, bool) {
+	// data/<namespace>/<folder>/<path>
+	if len(parts) < 4 || parts[0] != "data" || !ValidNamespace(parts[1]) {
+		return Resource{}, false
+	}
+
+	var namespace = parts[1]
+	var kind = parts[2]
+	var rest = parts[3:]
+
+	if singular, ok := renamedFolders[kind]; ok {
+		kind = singular
+	}
+
+	switch kind {
+	case "tags":
+		var registry = rest[0]
+		rest = rest[1:]
+
+		if singular, ok := renamedTagFolders[registry]; ok {
+			registry = singular
+		}
+
+		// worldgen registries are a level deeper, e.g. tags/worldgen/biome/
+		if registry == "worldgen" && len(rest) > 0 {
+			registry += "/" + rest[0]
+			rest = rest[1:]
+		}
+
+		kind = "tags/" + registry
+	case "worldgen":
+		kind += "/" + rest[0]
+		rest = rest[1:]
+	}
+
+	if len(rest) == 0 {
+		return Resource{}, false
+	}
+
+	var extension = ".json"
+	switch kind {
+	case FunctionKind:
+		extension = ".mcfunction"
+	case "structure":
+		extension = ".nbt"
+	}
+
+	var resourcePath = strings.Join(rest, "/")
+
+	if !strings.HasSuffix(resourcePath, extension) || !validResourcePath(resourcePath) {
+		return Resource{}, false
+	}
+
+	return Resource{Kind: kind, Location: namespace + ":" + strings.TrimSuffix(resourcePath, extension)}, true
+}
+
+// Index lists the namespaces and resources in the base data/ folder of archive and in the overlays meta declares.
+func Index(archive *zip.Reader, meta PackMeta) Contents {
+	var contents = Contents{Namespaces: []NamespaceContents{}, Resources: []Resource{}}
+	var namespaces = map[string]*NamespaceContents{}
+
+	// e.g. recipes/x.json and recipe/x.json are the same resource in packs spanning format 45
+	var seen = map[Resource]bool{}
+
+	for _, file := range archive.File {
+		if strings.HasSuffix(file.Name, "/") {
+			continue
+		}
+
+		var parts = strings.Split(file.Name, "/")
+		var overlay = ""
+
+		// <overlay>/data/...
+		if parts[0] != "data" {
+			if !slices.Contains(meta.Overlays, parts[0]) {
+				continue
+			}
+			overlay = parts[0]
+			parts = parts[1:]
+		}
+
+		resource, ok := ParseResource(parts)
+		if !ok {
+			continue
+		}
+		resource.Overlay = overlay
+
+		if seen[resource] {
+			continue
+		}
+		seen[resource] = true
+
+		var namespace = namespaces[resource.Namespace()]
+		if namespace == nil {
+			namespace = &NamespaceContents{Namespace: resource.Namespace()}
+			namespaces[resource.Namespace()] = namespace
+		}
+
+		if resource.Kind == FunctionKind {
+			namespace.Functions++
+			continue
+		}
+
+		namespace.Resources++
+		contents.Resources = append(contents.Resources, resource)
+	}
+
+	for _, namespace := range namespaces {
+		contents.Namespaces = append(contents.Namespaces, *namespace)
+	}
+
+	slices.SortFunc(contents.Namespaces, func(a, b NamespaceContents) int {
+		return strings.Compare(a.Namespace, b.Namespace)
+	})
+
+	slices.SortFunc(contents.Resources, func(a, b Resource) int {
+		return cmp.Or(
+			strings.Compare(a.Kind, b.Kind),
+			strings.Compare(a.Location, b.Location),
+			strings.Compare(a.Overlay, b.Overlay),
+		)
+	})
+
+	return contents
+}
@@ -0,0 +1,96 @@
+package datapack
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseResource(t *testing.T) {
+	var tests = []struct {
+		path     string
+		kind     string
+		location string
+	}{
+		{"data/x/function/main.mcfunction", FunctionKind, "x:main"},
+		{"data/x/functions/sub/main.mcfunction", FunctionKind, "x:sub/main"},
+		{"data/minecraft/loot_tables/entities/zombie.json", "loot_table", "minecraft:entities/zombie"},
+		{"data/x/recipes/a.json", "recipe", "x:a"},
+		{"data/x/item_modifiers/a.json", "item_modifier", "x:a"},
+		{"data/x/structures/house.nbt", "structure", "x:house"},
+		{"data/x/tags/function/load.json", "tags/function", "x:load"},
+		{"data/minecraft/tags/functions/tick.json", "tags/function", "minecraft:tick"},
+		{"data/x/tags/entity_types/mobs.json", "tags/entity_type", "x:mobs"},
+		{"data/x/tags/block/mineable/pickaxe.json", "tags/block", "x:mineable/pickaxe"},
+		{"data/x/tags/worldgen/biome/warm.json", "tags/worldgen/biome", "x:warm"},
+		{"data/x/worldgen/biome/warm.json", "worldgen/biome", "x:warm"},
+		{"data/x/worldgen/configured_feature/tree/oak.json", "worldgen/configured_feature", "x:tree/oak"},
+		{"data/x/dimension/void.json", "dimension", "x:void"},
+	}
+
+	for _, test := range tests {
+		resource, ok := ParseResource(strings.Split(test.path, "/"))
+
+		if !ok || resource.Kind != test.kind || resource.Location != test.location {
+			t.Errorf("ParseResource(%q) = %+v, %v, want %s %s", test.path, resource, ok, test.kind, test.location)
+		}
+	}
+}
+
+func TestParseResourceIgnored(t *testing.T) {
+	for _, path := range []string{
+		"pack.mcmeta",
+		"data/x/readme.txt",
+		"data/X/recipe/a.json",
+		"assets/x/models/a.json",
+		"data/x/function/main.txt",
+		"data/x/recipe/a.mcfunction",
+		"data/x/structure/house.json",
+		"data/x/tags/block.json",
+		"data/x/tags/worldgen/biome.json",
+		"data/x/worldgen/biome.json",
+		"data/x/recipe/A.json",
+	} {
+		if resource, ok := ParseResource(strings.Split(path, "/")); ok {
+			t.Errorf("ParseResource(%q) = %+v, want it ignored", path, resource)
+		}
+	}
+}
+
+func TestIndex(t *testing.T) {
+	var pack = testPack(t,
+		"pack.mcmeta", `{"pack":{"pack_format":41,"supported_formats":[41,61],"description":"test"},
+			"overlays":{"entries":[{"formats":[45,61],"directory":"new"}]}}`,
+		"data/x/functions/main.mcfunction", "say old",
+		"data/x/function/main.mcfunction", "say new",
+		"data/x/function/tick.mcfunction", "say tick",
+		"data/x/recipes/a.json", "{}",
+		"data/x/recipe/a.json", "{}",
+		"data/minecraft/tags/functions/tick.json", `{"values":["x:tick"]}`,
+		"data/x/tags/worldgen/biome/warm.json", `{"values":[]}`,
+		"new/data/x/recipe/a.json", "{}",
+		"old/data/x/recipe/b.json", "{}",
+		"data/x/readme.txt", "not a resource",
+	)
+
+	var contents = Index(pack.Archive, pack.Meta)
+
+	// plural and singular folders are the same resource, undeclared overlays aren't loaded
+	var want = []Resource{
+		{Kind: "recipe", Location: "x:a"},
+		{Kind: "recipe", Location: "x:a", Overlay: "new"},
+		{Kind: "tags/function", Location: "minecraft:tick"},
+		{Kind: "tags/worldgen/biome", Location: "x:warm"},
+	}
+	if !slices.Equal(contents.Resources, want) {
+		t.Errorf("expected resources %+v, got %+v", want, contents.Resources)
+	}
+
+	var namespaces = []NamespaceContents{
+		{Namespace: "minecraft", Functions: 0, Resources: 1},
+		{Namespace: "x", Functions: 2, Resources: 3},
+	}
+	if !slices.Equal(contents.Namespaces, namespaces) {
+		t.Errorf("expected namespaces %+v, got %+v", namespaces, contents.Namespaces)
+	}
+}
@@ -0,0 +1,84 @@
+package datapack
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+//go:embed minecraft_versions.json
+var minecraftVersionsJSON []byte
+
+const (
+	ReleaseVersion  = "release"
+	SnapshotVersion = "snapshot"
+)
+
+// GameVersion is a Minecraft version along with the pack formats it loads.
+type GameVersion struct {
+	ID                 string `json:"id"`
+	Type               string `json:"type"`
+	DataPackFormat     int    `json:"data_pack_format"`
+	ResourcePackFormat int    `json:"resource_pack_format"`
+}
+
+var (
+	bundledGameVersions     []GameVersion
+	bundledGameVersionsOnce sync.Once
+)
+
+// BundledGameVersions returns the Minecraft versions shipped with the API, oldest first.
+// They seed the version registry, which admins can edit from then on.
+func BundledGameVersions() []GameVersion {
+	bundledGameVersionsOnce.Do(func() {
+		// the file is embedded at build time, so it failing to parse is a programming error
+		if err := json.Unmarshal(minecraftVersionsJSON, &bundledGameVersions); err != nil {
+			panic(err)
+		}
+	})
+
+	return bundledGameVersions
+}
+
+// GameVersionsFor lists the releases whose data pack format falls in the range the pack supports.
+func GameVersionsFor(meta PackMeta, versions []GameVersion) []string {
+	var supported = []string{}
+
+	for _, version := range versions {
+		if version.Type == ReleaseVersion && meta.Supports(version.DataPackFormat) {
+			supported = append(supported, version.ID)
+		}
+	}
+
+	return supported
+}
+
+// CheckSupports compares the game versions an author claims against the registry and the pack's own metadata.
+// It returns the claims that aren't known game versions, and a warning for every known version
+// whose data pack format the pack.mcmeta doesn't declare support for.
+func CheckSupports(meta PackMeta, supports []string, versions []GameVersion) ([]string, []string) {
+	var unknown []string
+	var warnings = []string{}
+
+	var registry = make(map[string]GameVersion, len(versions))
+	for _, version := range versions {
+		registry[version.ID] = version
+	}
+
+	for _, claim := range supports {
+		version, ok := registry[claim]
+		if !ok {
+			unknown = append(unknown, claim)
+			continue
+		}
+
+		if !meta.Supports(version.DataPackFormat) {
+			warnings = append(warnings, fmt.Sprintf(
+				"claims %s (data pack format %d), but pack.mcmeta only supports formats %d to %d",
+				version.ID, version.DataPackFormat, meta.MinFormat, meta.MaxFormat))
+		}
+	}
+
+	return unknown, warnings
+}
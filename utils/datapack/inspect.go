@@ -0,0 +1,56 @@
+package datapack
+
+import (
+	"archive/zip"
+	"fmt"
+
+	derrors "github.com/HoodieRocks/dph-api-2/errors"
+)
+
+// Inspection is everything learned from reading an uploaded datapack.
+type Inspection struct {
+	Meta     PackMeta
+	Report   Report
+	Contents Contents
+}
+
+// InspectFile reads the pack.mcmeta of the zip at path and validates the datapack against it.
+// A pack that fails validation returns its report in a *ValidationError.
+func InspectFile(path string) (Inspection, error) {
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return Inspection{}, fmt.Errorf("%w: not a readable zip archive", derrors.ErrInvalidPackMeta)
+	}
+	defer archive.Close()
+
+	meta, err := ReadPackMeta(&archive.Reader)
+	if err != nil {
+		return Inspection{}, err
+	}
+
+	var inspection = Inspection{
+		Meta:     meta,
+		Report:   Validate(&archive.Reader, meta),
+		Contents: Index(&archive.Reader, meta),
+	}
+
+	if inspection.Report.HasErrors() {
+		return inspection, &ValidationError{Report: inspection.Report}
+	}
+
+	return inspection, nil
+}
+
+// IndexFile lists the contents of the zip at path. Unlike InspectFile it doesn't insist on a valid
+// pack.mcmeta, without one only the base data/ folder is indexed.
+func IndexFile(path string) (Contents, error) {
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return Contents{}, err
+	}
+	defer archive.Close()
+
+	meta, _ := ReadPackMeta(&archive.Reader)
+
+	return Index(&archive.Reader, meta), nil
+}
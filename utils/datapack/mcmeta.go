@@ -0,0 +1,283 @@
+package datapack
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+
+	derrors "github.com/HoodieRocks/dph-api-2/errors"
+)
+
+// MaxPackMetaSize caps how much of pack.mcmeta is read, real ones are a few hundred bytes.
+const MaxPackMetaSize = 64 * 1024
+
+// PackMeta is the pack section of a pack.mcmeta file.
+// MinFormat and MaxFormat are both PackFormat when the pack doesn't declare a range.
+type PackMeta struct {
+	PackFormat  int    `json:"pack_format"`
+	MinFormat   int    `json:"min_format"`
+	MaxFormat   int    `json:"max_format"`
+	Description string `json:"description"`
+
+	// Overlays are the extra root folders the pack layers on top of data/ for some formats
+	Overlays []string `json:"overlays,omitempty"`
+}
+
+// Supports reports whether the pack declares it can be loaded with format.
+func (meta PackMeta) Supports(format int) bool {
+	return format >= meta.MinFormat && format <= meta.MaxFormat
+}
+
+type rawPackMeta struct {
+	Pack *struct {
+		PackFormat       json.RawMessage `json:"pack_format"`
+		SupportedFormats json.RawMessage `json:"supported_formats"`
+		MinFormat        json.RawMessage `json:"min_format"`
+		MaxFormat        json.RawMessage `json:"max_format"`
+		Description      json.RawMessage `json:"description"`
+	} `json:"pack"`
+	Overlays *struct {
+		Entries []struct {
+			Directory string `json:"directory"`
+		} `json:"entries"`
+	} `json:"overlays"`
+}
+
+// ReadPackMeta parses the pack.mcmeta at the root of archive.
+func ReadPackMeta(archive *zip.Reader) (PackMeta, error) {
+	for _, file := range archive.File {
+		if file.Name != "pack.mcmeta" {
+			continue
+		}
+
+		reader, err := file.Open()
+		if err != nil {
+			return PackMeta{}, fmt.Errorf("%w: %v", derrors.ErrInvalidPackMeta, err)
+		}
+		defer reader.Close()
+
+		data, err := io.ReadAll(io.LimitReader(reader, MaxPackMetaSize+1))
+		if err != nil {
+			return PackMeta{}, fmt.Errorf("%w: %v", derrors.ErrInvalidPackMeta, err)
+		}
+		if len(data) > MaxPackMetaSize {
+			return PackMeta{}, fmt.Errorf("%w: file is too large", derrors.ErrInvalidPackMeta)
+		}
+
+		return ParsePackMeta(data)
+	}
+
+	// a common mistake is zipping the pack's folder instead of its contents
+	for _, file := range archive.File {
+		if path.Base(file.Name) == "pack.mcmeta" {
+			return PackMeta{}, fmt.Errorf("%w, found %s instead, zip the contents of the pack folder rather than the folder itself", derrors.ErrMissingPackMeta, file.Name)
+		}
+	}
+
+	return PackMeta{}, derrors.ErrMissingPackMeta
+}
+
+// ParsePackMeta parses the contents of a pack.mcmeta file.
+func ParsePackMeta(data []byte) (PackMeta, error) {
+	var meta PackMeta
+	var raw rawPackMeta
+
+	// editors on Windows like to save a byte order mark, Minecraft doesn't mind it so neither do we
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return meta, fmt.Errorf("%w: %v", derrors.ErrInvalidPackMeta, err)
+	}
+	if raw.Pack == nil {
+		return meta, fmt.Errorf("%w: missing pack section", derrors.ErrInvalidPackMeta)
+	}
+
+	// pack_format is optional since 25w31a when min_format and max_format are given
+	var err error
+	var hasFormat = raw.Pack.PackFormat != nil
+	if hasFormat {
+		meta.PackFormat, err = parseFormat(raw.Pack.PackFormat)
+		if err != nil {
+			return meta, fmt.Errorf("%w: pack_format %v", derrors.ErrInvalidPackMeta, err)
+		}
+	}
+
+	switch {
+	case raw.Pack.MinFormat != nil || raw.Pack.MaxFormat != nil:
+		meta.MinFormat, err = parseFormat(raw.Pack.MinFormat)
+		if err != nil {
+			return meta, fmt.Errorf("%w: min_format %v", derrors.ErrInvalidPackMeta, err)
+		}
+		meta.MaxFormat, err = parseFormat(raw.Pack.MaxFormat)
+		if err != nil {
+			return meta, fmt.Errorf("%w: max_format %v", derrors.ErrInvalidPackMeta, err)
+		}
+	case raw.Pack.SupportedFormats != nil:
+		meta.MinFormat, meta.MaxFormat, err = parseFormatRange(raw.Pack.SupportedFormats)
+		if err != nil {
+			return meta, fmt.Errorf("%w: supported_formats %v", derrors.ErrInvalidPackMeta, err)
+		}
+	case hasFormat:
+		meta.MinFormat, meta.MaxFormat = meta.PackFormat, meta.PackFormat
+	default:
+		return meta, fmt.Errorf("%w: missing pack_format", derrors.ErrInvalidPackMeta)
+	}
+
+	if meta.MinFormat > meta.MaxFormat {
+		return meta, fmt.Errorf("%w: supported formats range from %d down to %d", derrors.ErrInvalidPackMeta, meta.MinFormat, meta.MaxFormat)
+	}
+	if !hasFormat {
+		meta.PackFormat = meta.MaxFormat
+	}
+
+	if raw.Pack.Description != nil {
+		meta.Description, err = flattenTextComponent(raw.Pack.Description)
+		if err != nil {
+			return meta, fmt.Errorf("%w: description %v", derrors.ErrInvalidPackMeta, err)
+		}
+	}
+
+	if raw.Overlays != nil {
+		for _, entry := range raw.Overlays.Entries {
+			if !validOverlayDirectory(entry.Directory) {
+				return meta, fmt.Errorf("%w: invalid overlay directory %q", derrors.ErrInvalidPackMeta, entry.Directory)
+			}
+			meta.Overlays = append(meta.Overlays, entry.Directory)
+		}
+	}
+
+	return meta, nil
+}
+
+// validOverlayDirectory follows the game's rules for overlay folder names.
+func validOverlayDirectory(directory string) bool {
+	if directory == "" {
+		return false
+	}
+	for _, char := range directory {
+		if !(char >= 'a' && char <= 'z' || char >= '0' && char <= '9' || char == '_' || char == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// parseFormat reads a pack format, either a plain number or a [major, minor] pair.
+// Minor versions don't change which game versions can load a pack, so only the major is kept.
+func parseFormat(raw json.RawMessage) (int, error) {
+	var format int
+	if err := json.Unmarshal(raw, &format); err == nil {
+		if format <= 0 {
+			return 0, fmt.Errorf("must be positive")
+		}
+		return format, nil
+	}
+
+	var pair []int
+	if err := json.Unmarshal(raw, &pair); err != nil || len(pair) == 0 || len(pair) > 2 {
+		return 0, fmt.Errorf("must be a whole number")
+	}
+	if pair[0] <= 0 {
+		return 0, fmt.Errorf("must be positive")
+	}
+	return pair[0], nil
+}
+
+// parseFormatRange reads supported_formats, which is a single format, a [min, max] array
+// or a {"min_inclusive": min, "max_inclusive": max} object.
+func parseFormatRange(raw json.RawMessage) (int, int, error) {
+	var single int
+	if err := json.Unmarshal(raw, &single); err == nil {
+		if single <= 0 {
+			return 0, 0, fmt.Errorf("must be positive")
+		}
+		return single, single, nil
+	}
+
+	var pair []int
+	if err := json.Unmarshal(raw, &pair); err == nil {
+		if len(pair) != 2 || pair[0] <= 0 || pair[1] <= 0 {
+			return 0, 0, fmt.Errorf("must list two positive formats")
+		}
+		return pair[0], pair[1], nil
+	}
+
+	var object struct {
+		MinInclusive *int `json:"min_inclusive"`
+		MaxInclusive *int `json:"max_inclusive"`
+	}
+	if err := json.Unmarshal(raw, &object); err != nil || object.MinInclusive == nil || object.MaxInclusive == nil {
+		return 0, 0, fmt.Errorf("must be a format, a [min, max] array or a min_inclusive/max_inclusive object")
+	}
+	if *object.MinInclusive <= 0 || *object.MaxInclusive <= 0 {
+		return 0, 0, fmt.Errorf("must be positive")
+	}
+	return *object.MinInclusive, *object.MaxInclusive, nil
+}
+
+// flattenTextComponent turns a Minecraft text component into plain text, dropping the formatting.
+// Components are strings, numbers, booleans, arrays of components or objects with text/translate and extra.
+func flattenTextComponent(raw json.RawMessage) (string, error) {
+	var builder strings.Builder
+	var value any
+
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return "", err
+	}
+
+	if err := writeTextComponent(&builder, value, 0); err != nil {
+		return "", err
+	}
+	return builder.String(), nil
+}
+
+const maxComponentDepth = 32
+
+func writeTextComponent(builder *strings.Builder, value any, depth int) error {
+	if depth > maxComponentDepth {
+		return fmt.Errorf("is nested too deeply")
+	}
+
+	switch component := value.(type) {
+	case string:
+		builder.WriteString(component)
+	case json.Number:
+		builder.WriteString(component.String())
+	case bool:
+		builder.WriteString(strconv.FormatBool(component))
+	case []any:
+		for _, child := range component {
+			if err := writeTextComponent(builder, child, depth+1); err != nil {
+				return err
+			}
+		}
+	case map[string]any:
+		if text, ok := component["text"]; ok {
+			if err := writeTextComponent(builder, text, depth+1); err != nil {
+				return err
+			}
+		} else if fallback, ok := component["fallback"].(string); ok {
+			builder.WriteString(fallback)
+		} else if key, ok := component["translate"].(string); ok {
+			builder.WriteString(key)
+		}
+
+		if extra, ok := component["extra"]; ok {
+			if err := writeTextComponent(builder, extra, depth+1); err != nil {
+				return err
+			}
+		}
+	case nil:
+	default:
+		return fmt.Errorf("is not a text component")
+	}
+
+	return nil
+}
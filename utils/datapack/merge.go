@@ -0,0 +1,322 @@
+package datapack
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	derrors "github.com/HoodieRocks/dph-api-2/errors"
+)
+
+// MergeFormat is bumped whenever Merge writes a different pack for the same packs, so cached merges are redone.
+const MergeFormat = 1
+
+// ConflictOverlay is a resource several packs have that one of them changes in an overlay. Overlays are
+// layered over the whole merged pack, so it would hide the merged file on some formats.
+const ConflictOverlay = "overlay"
+
+// MergeConflictError is returned by Merge when packs have resources that can't be merged.
+type MergeConflictError struct {
+	Conflicts []ResourceConflict
+}
+
+func (err *MergeConflictError) Error() string {
+	return fmt.Sprintf("datapacks can't be merged, %d resources conflict", len(err.Conflicts))
+}
+
+// alwaysMergedTags are the function tags the game runs on load and every tick. Every pack needs its functions
+// in them, so they are merged even when a pack sets replace.
+var alwaysMergedTags = []string{"minecraft:load", "minecraft:tick"}
+
+// Merge writes a single datapack with the contents of packs, in load order, to w. Tags several packs have are
+// merged the way the game would merge them, files several packs have with the same content are kept once, and
+// overlays are kept under a folder per pack. Other files several packs have can't be merged, they are returned
+// as a *MergeConflictError rather than picking a winner. The packs must share a pack format.
+func Merge(packs []Pack, description string, w io.Writer) error {
+	report, err := FindConflicts(packs)
+	if err != nil {
+		return err
+	}
+
+	// a later pack replacing a tag is what the game does too, so only overrides are in the way
+	var conflicts = []ResourceConflict{}
+	for _, conflict := range report.Conflicts {
+		if conflict.Reason == ConflictOverride {
+			conflicts = append(conflicts, conflict)
+		}
+	}
+
+	var holders = map[Resource][]packFile{}
+	for i, pack := range packs {
+		for resource, file := range PackResources(pack) {
+			holders[resource] = append(holders[resource], packFile{pack: i, file: file})
+		}
+	}
+
+	var overlayConflicts = map[Resource]bool{}
+	for i, pack := range packs {
+		for _, file := range pack.Archive.File {
+			var overlay, resource, ok = packFileResource(pack, file)
+			if !ok || overlay == "" || len(holders[resource]) < 2 || overlayConflicts[resource] {
+				continue
+			}
+			overlayConflicts[resource] = true
+
+			var conflict = ResourceConflict{Kind: resource.Kind, Location: resource.Location, Reason: ConflictOverlay, Packs: []int{}}
+			for _, holder := range holders[resource] {
+				conflict.Packs = append(conflict.Packs, holder.pack)
+			}
+			conflict.Winner = &i
+			conflicts = append(conflicts, conflict)
+		}
+	}
+
+	if len(conflicts) > 0 {
+		return &MergeConflictError{Conflicts: conflicts}
+	}
+
+	// every pack has to load on the formats the merged pack declares
+	var minFormat, maxFormat = packs[0].Meta.MinFormat, packs[0].Meta.MaxFormat
+	for _, pack := range packs[1:] {
+		minFormat = max(minFormat, pack.Meta.MinFormat)
+		maxFormat = min(maxFormat, pack.Meta.MaxFormat)
+	}
+
+	if minFormat > maxFormat || minFormat <= 0 {
+		return derrors.ErrNoCommonPackFormat
+	}
+
+	var archive = zip.NewWriter(w)
+	var overlays = []map[string]json.RawMessage{}
+	var written = map[string]bool{}
+	var mergedTags = map[Resource][]byte{}
+
+	for i, pack := range packs {
+		entries, err := overlayEntries(pack)
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			var directory string
+			if err = json.Unmarshal(entry["directory"], &directory); err != nil {
+				return err
+			}
+			entry["directory"], _ = json.Marshal(mergedOverlay(i, directory))
+			overlays = append(overlays, entry)
+		}
+
+		for _, file := range pack.Archive.File {
+			var overlay, resource, ok = packFileResource(pack, file)
+
+			// the game doesn't load anything else, pack.mcmeta is written below
+			if !ok {
+				continue
+			}
+
+			var name = file.Name
+			if overlay != "" {
+				name = mergedOverlay(i, overlay) + strings.TrimPrefix(file.Name, overlay)
+			}
+
+			if written[name] {
+				continue
+			}
+			written[name] = true
+
+			// tags several packs have are merged, and written to every path they used, e.g. both
+			// tags/functions/ and tags/function/ in packs on either side of format 45
+			if overlay == "" && IsTagKind(resource.Kind) && len(holders[resource]) > 1 {
+				if mergedTags[resource] == nil {
+					if mergedTags[resource], err = mergeTag(resource, holders[resource]); err != nil {
+						return err
+					}
+				}
+
+				if err = writeZipFile(archive, name, mergedTags[resource]); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if err = copyZipFile(archive, name, file); err != nil {
+				return err
+			}
+		}
+	}
+
+	meta, err := mergedPackMeta(minFormat, maxFormat, description, overlays)
+	if err != nil {
+		return err
+	}
+
+	if err = writeZipFile(archive, "pack.mcmeta", meta); err != nil {
+		return err
+	}
+
+	return archive.Close()
+}
+
+// packFileResource names the resource a file of pack is, along with the overlay it is in.
+// It returns false for files that aren't resources the game would load.
+func packFileResource(pack Pack, file *zip.File) (string, Resource, bool) {
+	if strings.HasSuffix(file.Name, "/") {
+		return "", Resource{}, false
+	}
+
+	var parts = strings.Split(file.Name, "/")
+	var overlay = ""
+
+	if parts[0] != "data" {
+		if !slices.Contains(pack.Meta.Overlays, parts[0]) {
+			return "", Resource{}, false
+		}
+		overlay = parts[0]
+		parts = parts[1:]
+	}
+
+	resource, ok := ParseResource(parts)
+	return overlay, resource, ok
+}
+
+// mergedOverlay names the folder the overlay of the pack at position goes in, packs often use the same names.
+func mergedOverlay(position int, overlay string) string {
+	return fmt.Sprintf("pack%d_%s", position, overlay)
+}
+
+// mergeTag merges the tag files several packs have for resource, in load order. A tag with replace set drops
+// the entries of the packs before it, except for alwaysMergedTags.
+func mergeTag(resource Resource, files []packFile) ([]byte, error) {
+	var tags = make([]Tag, len(files))
+	var start = 0
+	var alwaysMerged = resource.Kind == TagFolder+"/"+FunctionKind && slices.Contains(alwaysMergedTags, resource.Location)
+
+	for i, file := range files {
+		tag, err := ReadTag(file.file)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %w", resource.Kind, resource.Location, err)
+		}
+		tags[i] = tag
+
+		if tag.Replace && !alwaysMerged {
+			start = i
+		}
+	}
+
+	// the first pack replacing the tag still drops the vanilla entries
+	var merged = Tag{Replace: tags[start].Replace && !alwaysMerged, Values: []json.RawMessage{}}
+	var seen = map[string]bool{}
+
+	for _, tag := range tags[start:] {
+		for _, value := range tag.Values {
+			var compact bytes.Buffer
+			if err := json.Compact(&compact, value); err != nil {
+				return nil, err
+			}
+
+			if seen[compact.String()] {
+				continue
+			}
+			seen[compact.String()] = true
+			merged.Values = append(merged.Values, compact.Bytes())
+		}
+	}
+
+	return json.MarshalIndent(merged, "", "  ")
+}
+
+// overlayEntries reads the overlay entries of the pack.mcmeta of pack as they are written, so their formats
+// can be kept while their folder is renamed.
+func overlayEntries(pack Pack) ([]map[string]json.RawMessage, error) {
+	if len(pack.Meta.Overlays) == 0 {
+		return nil, nil
+	}
+
+	for _, file := range pack.Archive.File {
+		if file.Name != "pack.mcmeta" {
+			continue
+		}
+
+		reader, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+
+		data, err := io.ReadAll(io.LimitReader(reader, MaxPackMetaSize+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(data) > MaxPackMetaSize {
+			return nil, fmt.Errorf("%w: file is too large", derrors.ErrInvalidPackMeta)
+		}
+
+		var raw struct {
+			Overlays struct {
+				Entries []map[string]json.RawMessage `json:"entries"`
+			} `json:"overlays"`
+		}
+		if err = json.Unmarshal(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")), &raw); err != nil {
+			return nil, fmt.Errorf("%w: %v", derrors.ErrInvalidPackMeta, err)
+		}
+
+		return raw.Overlays.Entries, nil
+	}
+
+	return nil, derrors.ErrMissingPackMeta
+}
+
+// mergedPackMeta writes the pack.mcmeta of a merged pack. The format range is written in both the old
+// pack_format and supported_formats fields and the min_format and max_format ones newer versions read.
+func mergedPackMeta(minFormat int, maxFormat int, description string, overlays []map[string]json.RawMessage) ([]byte, error) {
+	var pack = map[string]any{
+		"pack_format": maxFormat,
+		"min_format":  minFormat,
+		"max_format":  maxFormat,
+		"description": description,
+	}
+
+	if minFormat != maxFormat {
+		pack["supported_formats"] = map[string]int{"min_inclusive": minFormat, "max_inclusive": maxFormat}
+	}
+
+	var meta = map[string]any{"pack": pack}
+	if len(overlays) > 0 {
+		meta["overlays"] = map[string]any{"entries": overlays}
+	}
+
+	return json.MarshalIndent(meta, "", "  ")
+}
+
+// copyZipFile copies file to archive under name without recompressing it.
+func copyZipFile(archive *zip.Writer, name string, file *zip.File) error {
+	var header = file.FileHeader
+	header.Name = name
+
+	writer, err := archive.CreateRaw(&header)
+	if err != nil {
+		return err
+	}
+
+	reader, err := file.OpenRaw()
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(writer, reader)
+	return err
+}
+
+func writeZipFile(archive *zip.Writer, name string, data []byte) error {
+	writer, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate})
+	if err != nil {
+		return err
+	}
+
+	_, err = writer.Write(data)
+	return err
+}
@@ -0,0 +1,298 @@
+package datapack
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+
+	derrors "github.com/HoodieRocks/dph-api-2/errors"
+)
+
+// mergeTestPacks merges packs and returns the files of the merged pack by name.
+func mergeTestPacks(t *testing.T, packs ...Pack) map[string]*zip.File {
+	t.Helper()
+
+	var buffer bytes.Buffer
+	if err := Merge(packs, "merged", &buffer); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := zip.NewReader(bytes.NewReader(buffer.Bytes()), int64(buffer.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var merged = map[string]*zip.File{}
+	for _, file := range reader.File {
+		merged[file.Name] = file
+	}
+
+	return merged
+}
+
+// readTestTag reads the tag at name of a merged pack.
+func readTestTag(t *testing.T, merged map[string]*zip.File, name string) (bool, []string) {
+	t.Helper()
+
+	var file, ok = merged[name]
+	if !ok {
+		t.Fatalf("expected %s in the merged pack", name)
+	}
+
+	var tag struct {
+		Replace bool     `json:"replace"`
+		Values  []string `json:"values"`
+	}
+	if err := json.Unmarshal([]byte(readTestFile(t, file)), &tag); err != nil {
+		t.Fatal(err)
+	}
+
+	return tag.Replace, tag.Values
+}
+
+func TestMergeTags(t *testing.T) {
+	var first = testPack(t,
+		"pack.mcmeta", testMeta,
+		"data/minecraft/tags/function/load.json", `{"values":["a:load"]}`,
+		"data/minecraft/tags/function/tick.json", `{"values":["a:tick"]}`,
+		"data/x/tags/block/replaced.json", `{"values":["a:block"]}`,
+		"data/x/tags/block/merged.json", `{"values":["a:block", "shared:block"]}`,
+	)
+	var second = testPack(t,
+		"pack.mcmeta", testMeta,
+		"data/minecraft/tags/function/load.json", `{"replace":true,"values":["b:load"]}`,
+		"data/minecraft/tags/function/tick.json", `{"replace":true,"values":["b:tick"]}`,
+		"data/x/tags/block/replaced.json", `{"replace":true,"values":["b:block"]}`,
+		"data/x/tags/block/merged.json", `{"values":["shared:block", "b:block"]}`,
+	)
+	var third = testPack(t,
+		"pack.mcmeta", testMeta,
+		"data/x/tags/block/replaced.json", `{"values":["c:block"]}`,
+	)
+
+	var merged = mergeTestPacks(t, first, second, third)
+
+	var tests = []struct {
+		name    string
+		replace bool
+		values  []string
+	}{
+		{"data/minecraft/tags/function/load.json", false, []string{"a:load", "b:load"}},
+		{"data/minecraft/tags/function/tick.json", false, []string{"a:tick", "b:tick"}},
+		{"data/x/tags/block/replaced.json", true, []string{"b:block", "c:block"}},
+		{"data/x/tags/block/merged.json", false, []string{"a:block", "shared:block", "b:block"}},
+	}
+
+	for _, test := range tests {
+		replace, values := readTestTag(t, merged, test.name)
+
+		if replace != test.replace || !slices.Equal(values, test.values) {
+			t.Errorf("%s: expected replace %v with %v, got replace %v with %v", test.name, test.replace, test.values, replace, values)
+		}
+	}
+}
+
+func TestMergeTagPaths(t *testing.T) {
+	// packs on either side of format 45 name the folder differently, both have to get the merged tag
+	var old = testPack(t,
+		"pack.mcmeta", `{"pack":{"pack_format":41,"supported_formats":[41,61],"description":"old"}}`,
+		"data/minecraft/tags/functions/tick.json", `{"values":["a:tick"]}`,
+	)
+	var renamed = testPack(t,
+		"pack.mcmeta", testMeta,
+		"data/minecraft/tags/function/tick.json", `{"values":["b:tick"]}`,
+	)
+
+	var merged = mergeTestPacks(t, old, renamed)
+
+	for _, name := range []string{"data/minecraft/tags/functions/tick.json", "data/minecraft/tags/function/tick.json"} {
+		if _, values := readTestTag(t, merged, name); !slices.Equal(values, []string{"a:tick", "b:tick"}) {
+			t.Errorf("%s: expected both ticks, got %v", name, values)
+		}
+	}
+}
+
+func TestMergeOverlays(t *testing.T) {
+	var first = testPack(t,
+		"pack.mcmeta", `{"pack":{"pack_format":48,"supported_formats":[48,61],"description":"test"},
+			"overlays":{"entries":[{"formats":[57,61],"directory":"new"}]}}`,
+		"data/a/function/main.mcfunction", "say a",
+		"new/data/a/function/main.mcfunction", "say new a",
+	)
+	var second = testPack(t,
+		"pack.mcmeta", `{"pack":{"pack_format":48,"supported_formats":[48,61],"description":"test"},
+			"overlays":{"entries":[{"formats":{"min_inclusive":50,"max_inclusive":61},"directory":"new"}]}}`,
+		"data/b/function/main.mcfunction", "say b",
+		"new/data/b/function/main.mcfunction", "say new b",
+	)
+
+	var merged = mergeTestPacks(t, first, second)
+
+	var want = map[string]string{
+		"data/a/function/main.mcfunction":           "say a",
+		"data/b/function/main.mcfunction":           "say b",
+		"pack0_new/data/a/function/main.mcfunction": "say new a",
+		"pack1_new/data/b/function/main.mcfunction": "say new b",
+	}
+
+	for name, content := range want {
+		if file, ok := merged[name]; !ok || readTestFile(t, file) != content {
+			t.Errorf("expected %s with %q", name, content)
+		}
+	}
+
+	var meta struct {
+		Overlays struct {
+			Entries []map[string]json.RawMessage `json:"entries"`
+		} `json:"overlays"`
+	}
+	if err := json.Unmarshal([]byte(readTestFile(t, merged["pack.mcmeta"])), &meta); err != nil {
+		t.Fatal(err)
+	}
+
+	var entries = meta.Overlays.Entries
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 overlay entries, got %d", len(entries))
+	}
+
+	// the folders are renamed, everything else is kept as written
+	for i, want := range []struct{ directory, formats string }{
+		{`"pack0_new"`, `[57,61]`},
+		{`"pack1_new"`, `{"min_inclusive":50,"max_inclusive":61}`},
+	} {
+		var formats bytes.Buffer
+		if err := json.Compact(&formats, entries[i]["formats"]); err != nil {
+			t.Fatal(err)
+		}
+
+		if string(entries[i]["directory"]) != want.directory || formats.String() != want.formats {
+			t.Errorf("entry %d: expected %s with %s, got %s with %s", i, want.directory, want.formats, entries[i]["directory"], formats.String())
+		}
+	}
+}
+
+func TestMergeConflicts(t *testing.T) {
+	var first = testPack(t,
+		"pack.mcmeta", testMeta,
+		"data/minecraft/loot_table/entities/zombie.json", `{"pools":[1]}`,
+		"data/x/recipe/same.json", `{"same":true}`,
+	)
+	var second = testPack(t,
+		"pack.mcmeta", testMeta,
+		"data/minecraft/loot_table/entities/zombie.json", `{"pools":[2]}`,
+		"data/x/recipe/same.json", `{"same":true}`,
+	)
+
+	var err = Merge([]Pack{first, second}, "merged", &bytes.Buffer{})
+
+	var conflictErr *MergeConflictError
+	if !errors.As(err, &conflictErr) {
+		t.Fatalf("expected a MergeConflictError, got %v", err)
+	}
+
+	// identical files aren't in the way
+	if len(conflictErr.Conflicts) != 1 {
+		t.Fatalf("expected 1 conflict, got %v", conflictErr.Conflicts)
+	}
+
+	var conflict = conflictErr.Conflicts[0]
+	if conflict.Location != "minecraft:entities/zombie" || conflict.Reason != ConflictOverride || !slices.Equal(conflict.Packs, []int{0, 1}) {
+		t.Errorf("unexpected conflict %+v", conflict)
+	}
+}
+
+func TestMergeOverlayConflicts(t *testing.T) {
+	var first = testPack(t,
+		"pack.mcmeta", testMeta,
+		"data/minecraft/tags/block/mineable/pickaxe.json", `{"values":["a:ore"]}`,
+	)
+	var second = testPack(t,
+		"pack.mcmeta", `{"pack":{"pack_format":48,"supported_formats":[48,61],"description":"test"},
+			"overlays":{"entries":[{"formats":[57,61],"directory":"new"}]}}`,
+		"data/minecraft/tags/block/mineable/pickaxe.json", `{"values":["b:ore"]}`,
+		"new/data/minecraft/tags/block/mineable/pickaxe.json", `{"values":["b:new_ore"]}`,
+	)
+
+	var err = Merge([]Pack{first, second}, "merged", &bytes.Buffer{})
+
+	var conflictErr *MergeConflictError
+	if !errors.As(err, &conflictErr) || len(conflictErr.Conflicts) != 1 || conflictErr.Conflicts[0].Reason != ConflictOverlay {
+		t.Fatalf("expected an overlay conflict, got %v", err)
+	}
+}
+
+func TestMergePackFormats(t *testing.T) {
+	var tests = []struct {
+		name   string
+		metas  []string
+		min    int
+		max    int
+		err    error
+		ranged bool
+	}{
+		{"overlapping ranges", []string{
+			`{"pack":{"pack_format":48,"supported_formats":[48,61],"description":"a"}}`,
+			`{"pack":{"pack_format":57,"supported_formats":{"min_inclusive":41,"max_inclusive":57},"description":"b"}}`,
+		}, 48, 57, nil, true},
+		{"single format", []string{
+			`{"pack":{"pack_format":48,"supported_formats":[48,61],"description":"a"}}`,
+			`{"pack":{"pack_format":61,"description":"b"}}`,
+		}, 61, 61, nil, false},
+		{"min and max format", []string{
+			`{"pack":{"min_format":80,"max_format":88,"description":"a"}}`,
+			`{"pack":{"pack_format":81,"supported_formats":[71,85],"description":"b"}}`,
+		}, 80, 85, nil, true},
+		{"no common format", []string{
+			`{"pack":{"pack_format":48,"description":"a"}}`,
+			`{"pack":{"pack_format":61,"description":"b"}}`,
+		}, 0, 0, derrors.ErrNoCommonPackFormat, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var packs []Pack
+			for _, meta := range test.metas {
+				packs = append(packs, testPack(t, "pack.mcmeta", meta))
+			}
+
+			var buffer bytes.Buffer
+			var err = Merge(packs, "merged", &buffer)
+
+			if test.err != nil {
+				if !errors.Is(err, test.err) {
+					t.Fatalf("expected %v, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			reader, err := zip.NewReader(bytes.NewReader(buffer.Bytes()), int64(buffer.Len()))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			meta, err := ReadPackMeta(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if meta.MinFormat != test.min || meta.MaxFormat != test.max || meta.PackFormat != test.max {
+				t.Errorf("expected formats %d to %d, got %+v", test.min, test.max, meta)
+			}
+
+			// older games only read supported_formats, a single format doesn't need it
+			var raw rawPackMeta
+			if err = json.Unmarshal([]byte(readTestFile(t, reader.File[len(reader.File)-1])), &raw); err != nil {
+				t.Fatal(err)
+			}
+			if (raw.Pack.SupportedFormats != nil) != test.ranged {
+				t.Errorf("expected supported_formats %v, got %s", test.ranged, raw.Pack.SupportedFormats)
+			}
+		})
+	}
+}
@@ -0,0 +1,20 @@
+package datapack
+
+import "slices"
+
+// SharedNamespaces are used by many packs on purpose and can't be claimed by a project: the game's own,
+// and c, which packs and mods put the tags they share in.
+var SharedNamespaces = []string{"minecraft", "c"}
+
+// ClaimableNamespaces returns the namespaces of contents that aren't SharedNamespaces.
+func (contents Contents) ClaimableNamespaces() []string {
+	var namespaces = []string{}
+
+	for _, namespace := range contents.Namespaces {
+		if !slices.Contains(SharedNamespaces, namespace.Namespace) {
+			namespaces = append(namespaces, namespace.Namespace)
+		}
+	}
+
+	return namespaces
+}
@@ -0,0 +1,49 @@
+package datapack
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// TagFolder is the folder tag files are in, tag kinds start with it, e.g. tags/function.
+const TagFolder = "tags"
+
+// Tag is a tag file. Values are kept as they are written, either a plain id or an object with an id
+// and whether it is required.
+type Tag struct {
+	Replace bool              `json:"replace,omitempty"`
+	Values  []json.RawMessage `json:"values"`
+}
+
+// IsTagKind reports whether resources of kind are tags.
+func IsTagKind(kind string) bool {
+	return strings.HasPrefix(kind, TagFolder+"/")
+}
+
+// ReadTag parses a tag file from an archive.
+func ReadTag(file *zip.File) (Tag, error) {
+	reader, err := file.Open()
+	if err != nil {
+		return Tag{}, err
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(io.LimitReader(reader, MaxJSONSize+1))
+	if err != nil {
+		return Tag{}, err
+	}
+	if len(data) > MaxJSONSize {
+		return Tag{}, fmt.Errorf("file is larger than %d bytes", MaxJSONSize)
+	}
+
+	var tag Tag
+	if err = json.Unmarshal(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")), &tag); err != nil {
+		return Tag{}, err
+	}
+
+	return tag, nil
+}
@@ -0,0 +1,334 @@
+package datapack
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// SingularFoldersFormat is the data pack format (24w21a, released in 1.21) that renamed
+// the plural resource folders, e.g. functions/ became function/.
+const SingularFoldersFormat = 45
+
+// MaxJSONSize caps how much of a single JSON file is read when validating it.
+const MaxJSONSize = 4 * 1024 * 1024
+
+// renamedFolders maps the plural folder names used before SingularFoldersFormat to their new names.
+var renamedFolders = map[string]string{
+	"functions":      "function",
+	"advancements":   "advancement",
+	"recipes":        "recipe",
+	"loot_tables":    "loot_table",
+	"predicates":     "predicate",
+	"item_modifiers": "item_modifier",
+	"structures":     "structure",
+}
+
+// renamedTagFolders is renamedFolders for the registries under tags/.
+var renamedTagFolders = map[string]string{
+	"functions":    "function",
+	"items":        "item",
+	"blocks":       "block",
+	"entity_types": "entity_type",
+	"fluids":       "fluid",
+	"game_events":  "game_event",
+}
+
+// stableFolders are resource folders whose name doesn't depend on the pack format.
+var stableFolders = map[string]bool{
+	"tags":                 true,
+	"worldgen":             true,
+	"dimension":            true,
+	"dimension_type":       true,
+	"damage_type":          true,
+	"chat_type":            true,
+	"trim_pattern":         true,
+	"trim_material":        true,
+	"banner_pattern":       true,
+	"painting_variant":     true,
+	"wolf_variant":         true,
+	"wolf_sound_variant":   true,
+	"cat_variant":          true,
+	"chicken_variant":      true,
+	"cow_variant":          true,
+	"frog_variant":         true,
+	"pig_variant":          true,
+	"jukebox_song":         true,
+	"enchantment":          true,
+	"enchantment_provider": true,
+	"instrument":           true,
+	"trial_spawner":        true,
+	"test_instance":        true,
+	"test_environment":     true,
+	"dialog":               true,
+}
+
+// modMarkers are files that only show up in mods, never in datapacks.
+var modMarkers = []string{
+	"META-INF/MANIFEST.MF",
+	"META-INF/mods.toml",
+	"META-INF/neoforge.mods.toml",
+	"fabric.mod.json",
+	"quilt.mod.json",
+}
+
+// Issue is a single problem found in an archive.
+type Issue struct {
+	Path    string `json:"path,omitempty"`
+	Message string `json:"message"`
+}
+
+func (issue Issue) String() string {
+	if issue.Path == "" {
+		return issue.Message
+	}
+	return issue.Path + ": " + issue.Message
+}
+
+// Report lists what is wrong with a datapack. Errors make the pack unusable,
+// warnings are worth telling the author and players about but the pack still loads.
+type Report struct {
+	Errors   []Issue `json:"errors"`
+	Warnings []Issue `json:"warnings"`
+
+	seen map[string]bool
+}
+
+func (report *Report) add(issues *[]Issue, issue Issue) {
+	if report.seen == nil {
+		report.seen = make(map[string]bool)
+	}
+	// a misnamed folder would otherwise be reported once per file in it
+	if report.seen[issue.String()] {
+		return
+	}
+	report.seen[issue.String()] = true
+	*issues = append(*issues, issue)
+}
+
+func (report *Report) errorf(path string, format string, args ...any) {
+	report.add(&report.Errors, Issue{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (report *Report) warnf(path string, format string, args ...any) {
+	report.add(&report.Warnings, Issue{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (report Report) HasErrors() bool {
+	return len(report.Errors) > 0
+}
+
+// WarningStrings flattens the warnings for storing on a version.
+func (report Report) WarningStrings() []string {
+	var warnings = []string{}
+	for _, warning := range report.Warnings {
+		warnings = append(warnings, warning.String())
+	}
+	return warnings
+}
+
+// ValidationError is returned when an archive is not a usable datapack.
+type ValidationError struct {
+	Report Report
+}
+
+func (err *ValidationError) Error() string {
+	return fmt.Sprintf("datapack failed validation with %d errors", len(err.Report.Errors))
+}
+
+// Validate walks the archive and checks it is laid out like a datapack for the formats meta declares:
+// it has data/<namespace>/ folders with valid names, folder names its formats know about,
+// and every JSON file in it parses.
+func Validate(archive *zip.Reader, meta PackMeta) Report {
+	var report = Report{Errors: []Issue{}, Warnings: []Issue{}}
+
+	var roots = map[string]bool{"data": true}
+	for _, overlay := range meta.Overlays {
+		roots[overlay] = true
+	}
+
+	var dataFiles = 0
+
+	for _, file := range archive.File {
+		var name = file.Name
+
+		if strings.HasSuffix(name, "/") {
+			continue
+		}
+
+		if slices.Contains(modMarkers, name) {
+			report.errorf(name, "this is a mod, not a datapack")
+			continue
+		}
+		if strings.HasSuffix(name, ".class") {
+			report.errorf("", "archive contains Java classes, this is a mod, not a datapack")
+			continue
+		}
+
+		var parts = strings.Split(name, "/")
+
+		// pack.mcmeta, pack.png and readme-like files at the root are fine
+		if len(parts) == 1 {
+			if name != "pack.mcmeta" && name != "pack.png" && !isDocumentation(name) {
+				report.warnf(name, "file is ignored by Minecraft")
+			}
+			continue
+		}
+
+		var overlay = parts[0] != "data"
+
+		// data/... or <overlay>/data/...
+		if overlay {
+			if !roots[parts[0]] {
+				if parts[0] == "assets" {
+					report.warnf("assets/", "resource pack files are ignored in a datapack, upload them as a resource pack")
+				} else if parts[0] != "META-INF" {
+					report.warnf(parts[0]+"/", "folder is ignored by Minecraft")
+				}
+				continue
+			}
+			if len(parts) < 2 || parts[1] != "data" {
+				continue
+			}
+			parts = parts[1:]
+		}
+
+		dataFiles++
+		validateDataFile(&report, file, name, parts, meta, overlay)
+	}
+
+	if dataFiles == 0 {
+		report.errorf("data/", "datapack has no files in its data folder")
+	}
+
+	return report
+}
+
+// validateDataFile checks one file under a data/ folder, parts is its path starting at data.
+func validateDataFile(report *Report, file *zip.File, name string, parts []string, meta PackMeta, overlay bool) {
+	// data/<namespace>/<folder>/<path>
+	if len(parts) < 4 {
+		report.warnf(name, "file is not inside a data/<namespace>/<folder>/ folder and is ignored")
+		return
+	}
+
+	var namespace = parts[1]
+
+	if !ValidNamespace(namespace) {
+		var namespacePath = strings.TrimSuffix(name, strings.Join(parts[2:], "/"))
+		report.errorf(namespacePath, "namespace %q may only contain a-z, 0-9, _, - and .", namespace)
+		return
+	}
+
+	if !validResourcePath(strings.Join(parts[3:], "/")) {
+		report.errorf(name, "file name may only contain a-z, 0-9, _, -, . and /, Minecraft will not load it")
+	}
+
+	// overlays exist to carry different folder names per format, so only check the base folder
+	if !overlay {
+		checkFolderName(report, parts, meta)
+	}
+
+	if strings.HasSuffix(name, ".json") {
+		if err := validateJSON(file); err != nil {
+			report.errorf(name, "invalid JSON: %v", err)
+		}
+	}
+}
+
+// checkFolderName makes sure the resource folder exists in the formats the pack declares.
+func checkFolderName(report *Report, parts []string, meta PackMeta) {
+	var folder = parts[2]
+	var folderPath = strings.Join(parts[:3], "/") + "/"
+	var renames = renamedFolders
+
+	if folder == "tags" {
+		if len(parts) < 5 {
+			return
+		}
+		folder = parts[3]
+		folderPath = strings.Join(parts[:4], "/") + "/"
+		renames = renamedTagFolders
+	}
+
+	if singular, ok := renames[folder]; ok {
+		if meta.MinFormat >= SingularFoldersFormat {
+			report.errorf(folderPath, "folder was renamed to %s/ in pack format %d (1.21) and won't be loaded", singular, SingularFoldersFormat)
+		} else if meta.MaxFormat >= SingularFoldersFormat {
+			report.warnf(folderPath, "folder was renamed to %s/ in pack format %d (1.21) and won't be loaded on newer versions", singular, SingularFoldersFormat)
+		}
+		return
+	}
+
+	for plural, singular := range renames {
+		if folder != singular {
+			continue
+		}
+		if meta.MaxFormat < SingularFoldersFormat {
+			report.errorf(folderPath, "folder is only read since pack format %d (1.21), use %s/ instead", SingularFoldersFormat, plural)
+		} else if meta.MinFormat < SingularFoldersFormat {
+			report.warnf(folderPath, "folder is only read since pack format %d (1.21) and won't be loaded on older versions", SingularFoldersFormat)
+		}
+		return
+	}
+
+	// tags can be made for any registry, only the top level folders are a closed set
+	if parts[2] != "tags" && !stableFolders[folder] {
+		report.warnf(folderPath, "unknown folder, Minecraft will ignore it")
+	}
+}
+
+func validateJSON(file *zip.File) error {
+	reader, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(io.LimitReader(reader, MaxJSONSize+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > MaxJSONSize {
+		return fmt.Errorf("file is larger than %d bytes", MaxJSONSize)
+	}
+
+	var value json.RawMessage
+	return json.Unmarshal(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")), &value)
+}
+
+// ValidNamespace reports whether namespace follows the game's rules for resource location namespaces.
+func ValidNamespace(namespace string) bool {
+	if namespace == "" {
+		return false
+	}
+	for _, char := range namespace {
+		if !(char >= 'a' && char <= 'z' || char >= '0' && char <= '9' || char == '_' || char == '-' || char == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// validResourcePath follows the game's rules for resource location paths.
+func validResourcePath(resourcePath string) bool {
+	for _, char := range resourcePath {
+		if !(char >= 'a' && char <= 'z' || char >= '0' && char <= '9' || char == '_' || char == '-' || char == '.' || char == '/') {
+			return false
+		}
+	}
+	return true
+}
+
+func isDocumentation(name string) bool {
+	var lower = strings.ToLower(name)
+	return strings.HasPrefix(lower, "readme") ||
+		strings.HasPrefix(lower, "license") ||
+		strings.HasPrefix(lower, "changelog") ||
+		strings.HasSuffix(lower, ".md") ||
+		strings.HasSuffix(lower, ".txt")
+}
@@ -0,0 +1,179 @@
+package db
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/HoodieRocks/dph-api-2/utils/datapack"
+	"github.com/HoodieRocks/dph-api-2/utils/paging"
+	"github.com/jackc/pgx/v5"
+)
+
+// CreateVersionContents stores the content index of a version and marks it as indexed.
+func (pg *postgres) CreateVersionContents(tx pgx.Tx, versionId string, contents datapack.Contents) error {
+	var namespaces = make([][]any, 0, len(contents.Namespaces))
+	for _, namespace := range contents.Namespaces {
+		namespaces = append(namespaces, []any{versionId, namespace.Namespace, namespace.Functions, namespace.Resources})
+	}
+
+	_, err := tx.CopyFrom(context.Background(),
+		pgx.Identifier{"version_namespaces"},
+		[]string{"version", "namespace", "functions", "resources"},
+		pgx.CopyFromRows(namespaces))
+
+	if err != nil {
+		return err
+	}
+
+	// packs can have thousands of resources, so they are copied in rather than inserted one by one
+	var resources = make([][]any, 0, len(contents.Resources))
+	for _, resource := range contents.Resources {
+		resources = append(resources, []any{versionId, resource.Kind, resource.Location, resource.Overlay})
+	}
+
+	_, err = tx.CopyFrom(context.Background(),
+		pgx.Identifier{"version_resources"},
+		[]string{"version", "kind", "location", "overlay"},
+		pgx.CopyFromRows(resources))
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(context.Background(), `UPDATE versions SET contents_indexed = TRUE WHERE id = $1`, versionId)
+	return err
+}
+
+// GetVersionContents returns the content index of a version, optionally only the resources of one kind
+// and in one namespace.
+func (pg *postgres) GetVersionContents(versionId string, kind string, namespace string) (datapack.Contents, error) {
+	var contents datapack.Contents
+
+	rows, err := pg.Db.Query(context.Background(),
+		`SELECT namespace, functions, resources FROM version_namespaces
+			WHERE version = $1 AND ($2 = '' OR namespace = $2)
+			ORDER BY namespace`,
+		versionId, namespace)
+
+	if err != nil {
+		return contents, err
+	}
+
+	contents.Namespaces, err = pgx.CollectRows(rows, pgx.RowToStructByName[datapack.NamespaceContents])
+
+	if err != nil {
+		return contents, err
+	}
+
+	rows, err = pg.Db.Query(context.Background(),
+		`SELECT kind, location, overlay FROM version_resources
+			WHERE version = $1 AND ($2 = '' OR kind = $2) AND ($3 = '' OR location LIKE $4)
+			ORDER BY kind, location, overlay`,
+		versionId, kind, namespace, escapeLike(namespace)+":%")
+
+	if err != nil {
+		return contents, err
+	}
+
+	contents.Resources, err = pgx.CollectRows(rows, pgx.RowToStructByName[datapack.Resource])
+
+	return contents, err
+}
+
+// ListVersionsWithoutContents returns the versions uploaded before their contents were indexed.
+func (pg *postgres) ListVersionsWithoutContents() ([]Version, error) {
+	var rows, err = pg.Db.Query(context.Background(), `SELECT * FROM versions WHERE NOT contents_indexed`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowToStructByName[Version])
+}
+
+// ContentQuery finds resources by their resource location and, optionally, their kind. Both may contain
+// * wildcards that match any run of characters, e.g. minecraft:entities/* matches every entity loot table.
+// Channels are the release channels the latest version is picked from, see ChannelsUpTo.
+type ContentQuery struct {
+	Kind     string
+	Location string
+	Channels []string
+}
+
+// ContentSearchResult is a project whose latest version has resources matching a ContentQuery.
+type ContentSearchResult struct {
+	ProjectRow
+	LatestVersion    string              `json:"latest_version"`
+	MatchedResources []datapack.Resource `json:"matched_resources"` // at most MaxMatchedResources of them
+}
+
+// MaxMatchedResources caps how many matching resources are returned per project.
+const MaxMatchedResources = 50
+
+// latestVersion picks the version of each project GetLatestVersion would, the newest in channels that hasn't
+// been yanked.
+func (qb *queryBuilder) latestVersion(channels []string) string {
+	return `SELECT versions.id AS latest_version FROM versions
+		WHERE versions.project = projects.id AND versions.channel = ANY(` + qb.arg(channels) + `) AND NOT versions.yanked
+		ORDER BY versions.version_sort DESC, versions.creation DESC, versions.id COLLATE "C" DESC
+		LIMIT 1`
+}
+
+// wildcardPattern turns a pattern with * wildcards into a LIKE pattern that matches nothing else.
+func wildcardPattern(pattern string) string {
+	return strings.ReplaceAll(escapeLike(pattern), "*", "%")
+}
+
+// contentSearchFrom joins the live projects matching filter to their latest version, and keeps those that
+// have a resource matching query. It returns the FROM clause and the condition resources have to match.
+func (qb *queryBuilder) contentSearchFrom(query ContentQuery, filter ProjectFilter) (string, string) {
+	qb.applyProjectFilter(filter)
+
+	var match = "r.location LIKE " + qb.arg(wildcardPattern(query.Location))
+	if query.Kind != "" {
+		match += " AND r.kind LIKE " + qb.arg(wildcardPattern(query.Kind))
+	}
+
+	qb.where(`EXISTS (SELECT 1 FROM version_resources r WHERE r.version = latest.latest_version AND ` + match + `)`)
+
+	return ` FROM projects JOIN LATERAL (` + qb.latestVersion(query.Channels) + `) latest ON TRUE`, match
+}
+
+// SearchProjectContents pages through the live projects whose latest version contains resources matching query,
+// along with the resources that matched.
+func (pg *postgres) SearchProjectContents(query ContentQuery, filter ProjectFilter, sort ProjectSort, req paging.Request) ([]ContentSearchResult, error) {
+	var qb queryBuilder
+	var from, match = qb.contentSearchFrom(query, filter)
+	var page = sort.keyset(&qb, req)
+
+	var rows, err = pg.Db.Query(context.Background(),
+		`SELECT `+PROJECT_COLUMNS+`,
+			latest.latest_version,
+			(SELECT jsonb_agg(m ORDER BY m.kind, m.location, m.overlay) FROM (
+				SELECT r.kind, r.location, r.overlay FROM version_resources r
+				WHERE r.version = latest.latest_version AND `+match+`
+				ORDER BY r.kind, r.location, r.overlay
+				LIMIT `+strconv.Itoa(MaxMatchedResources)+`
+			) m) AS matched_resources`+
+			sortKeyColumn(sort.Expr)+
+			from+qb.whereClause()+page,
+		qb.args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowToStructByName[ContentSearchResult])
+}
+
+// CountProjectContents counts every project SearchProjectContents would page through.
+func (pg *postgres) CountProjectContents(query ContentQuery, filter ProjectFilter) (int, error) {
+	var qb queryBuilder
+	var from, _ = qb.contentSearchFrom(query, filter)
+
+	var total = 0
+	var err = pg.Db.QueryRow(context.Background(), `SELECT count(*)`+from+qb.whereClause(), qb.args...).Scan(&total)
+
+	return total, err
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 	"sync"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/labstack/gommon/log"
 )
@@ -117,6 +118,188 @@ func CreateTables(pg *postgres) {
 		panic(err)
 	}
 
+	// pack.mcmeta metadata of the uploaded datapack
+	execSchema(tx, "version pack metadata", `ALTER TABLE versions
+		ADD COLUMN IF NOT EXISTS pack_format		INTEGER,
+		ADD COLUMN IF NOT EXISTS min_format			INTEGER,
+		ADD COLUMN IF NOT EXISTS max_format			INTEGER,
+		ADD COLUMN IF NOT EXISTS pack_description	TEXT`)
+
+	// registry of Minecraft versions and the pack formats they load, seeded by SeedGameVersions
+	execSchema(tx, "minecraft version table", `CREATE TABLE IF NOT EXISTS minecraft_versions (
+		id						TEXT			PRIMARY KEY,
+		type					VARCHAR(20)		NOT NULL,
+		data_pack_format		INTEGER			NOT NULL,
+		resource_pack_format	INTEGER			NOT NULL
+	)`)
+
+	// problems found with an upload that don't block it, e.g. supports claims the pack format can't back up
+	execSchema(tx, "version warnings", `ALTER TABLE versions ADD COLUMN IF NOT EXISTS warnings TEXT[] NOT NULL DEFAULT '{}'`)
+
+	// weighted full-text search document, title > description > body
+	execSchema(tx, "project search column", `ALTER TABLE projects ADD COLUMN IF NOT EXISTS fts_column tsvector
+		GENERATED ALWAYS AS (
+			setweight(to_tsvector('english', title), 'A') ||
+			setweight(to_tsvector('english', description), 'B') ||
+			setweight(to_tsvector('english', body), 'C')
+		) STORED`)
+
+	execSchema(tx, "project search index", `CREATE INDEX IF NOT EXISTS projects_fts_idx ON projects USING GIN (fts_column)`)
+
+	// trigram indexes back fuzzy matching and the ILIKE substring search,
+	// the pattern index backs prefix lookups for autocomplete
+	execSchema(tx, "trigram extension", `CREATE EXTENSION IF NOT EXISTS pg_trgm`)
+	execSchema(tx, "project title trigram index", `CREATE INDEX IF NOT EXISTS projects_title_trgm_idx ON projects USING GIN (title gin_trgm_ops)`)
+	execSchema(tx, "project slug trigram index", `CREATE INDEX IF NOT EXISTS projects_slug_trgm_idx ON projects USING GIN (slug gin_trgm_ops)`)
+	execSchema(tx, "project description trigram index", `CREATE INDEX IF NOT EXISTS projects_description_trgm_idx ON projects USING GIN (description gin_trgm_ops)`)
+	execSchema(tx, "project title prefix index", `CREATE INDEX IF NOT EXISTS projects_title_prefix_idx ON projects (LOWER(title) text_pattern_ops)`)
+
+	// digests of the version files, so tools can find a version from a file they already have
+	execSchema(tx, "version hashes", `ALTER TABLE versions
+		ADD COLUMN IF NOT EXISTS hashes		JSONB,
+		ADD COLUMN IF NOT EXISTS rp_hashes	JSONB`)
+
+	execSchema(tx, "version changelog and yanking", `ALTER TABLE versions
+		ADD COLUMN IF NOT EXISTS changelog	TEXT	NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS yanked		BOOLEAN	NOT NULL DEFAULT FALSE`)
+
+	execSchema(tx, "version channel", `ALTER TABLE versions ADD COLUMN IF NOT EXISTS channel VARCHAR(10) NOT NULL DEFAULT 'release'
+		CHECK (channel IN ('release', 'beta', 'alpha'))`)
+
+	// semantic version order of version_code, filled in by BackfillVersionSort for older versions
+	execSchema(tx, "version sort key", `ALTER TABLE versions ADD COLUMN IF NOT EXISTS version_sort TEXT COLLATE "C" NOT NULL DEFAULT ''`)
+	// version codes address versions, so they are unique per project
+	dedupVersionCodes(tx)
+	execSchema(tx, "version code index", `DROP INDEX IF EXISTS versions_project_code_idx`)
+	execSchema(tx, "version code unique index", `CREATE UNIQUE INDEX IF NOT EXISTS versions_project_code_key ON versions (project, version_code)`)
+	execSchema(tx, "version sort index", `CREATE INDEX IF NOT EXISTS versions_project_sort_idx ON versions (project, version_sort DESC, creation DESC)`)
+
+	// original names of the uploaded files, stored files get a random suffix
+	execSchema(tx, "version file names", `ALTER TABLE versions
+		ADD COLUMN IF NOT EXISTS file_name		TEXT,
+		ADD COLUMN IF NOT EXISTS rp_file_name	TEXT`)
+
+	// changelogs rendered to sanitized HTML, re-rendered by BackfillChangelogHTML when the renderer changes
+	execSchema(tx, "version changelog html", `ALTER TABLE versions
+		ADD COLUMN IF NOT EXISTS changelog_html		TEXT	NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS changelog_renderer	INTEGER	NOT NULL DEFAULT 0`)
+
+	// project bodies rendered to sanitized HTML, re-rendered by BackfillProjectBodyHTML when the renderer changes
+	execSchema(tx, "project body html", `ALTER TABLE projects
+		ADD COLUMN IF NOT EXISTS body_html		TEXT	NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS body_renderer	INTEGER	NOT NULL DEFAULT 0`)
+
+	// raw download log, kept for a few days until it is rolled up into download_stats.
+	// The unique constraint is what dedups repeat downloads within a window.
+	// Neither table references versions, so stats outlive deleted versions.
+	execSchema(tx, "download event table", `CREATE TABLE IF NOT EXISTS download_events (
+		version			TEXT			NOT NULL,
+		project			TEXT			NOT NULL,
+		day				DATE			NOT NULL,
+		window_start	TIMESTAMP		NOT NULL,
+		client			VARCHAR(20)		NOT NULL,
+		referrer		VARCHAR(20)		NOT NULL,
+		visitor			TEXT			NOT NULL,
+		UNIQUE (version, visitor, window_start)
+	)`)
+
+	execSchema(tx, "download salt table", `CREATE TABLE IF NOT EXISTS download_salts (
+		day		DATE	PRIMARY KEY,
+		salt	BYTEA	NOT NULL
+	)`)
+
+	execSchema(tx, "download stats table", `CREATE TABLE IF NOT EXISTS download_stats (
+		project		TEXT			NOT NULL,
+		version		TEXT			NOT NULL,
+		day			DATE			NOT NULL,
+		client		VARCHAR(20)		NOT NULL,
+		referrer	VARCHAR(20)		NOT NULL,
+		downloads	INTEGER			NOT NULL,
+		PRIMARY KEY (version, day, client, referrer)
+	)`)
+
+	execSchema(tx, "download stats index", `CREATE INDEX IF NOT EXISTS download_stats_project_day_idx ON download_stats (project, day)`)
+
+	// every file of a version, the datapack itself, its resource pack and optional add-ons
+	execSchema(tx, "version file table", `CREATE TABLE IF NOT EXISTS version_files (
+		id				TEXT			PRIMARY KEY,
+		version			TEXT			NOT NULL REFERENCES versions(id) ON DELETE CASCADE,
+		role			VARCHAR(20)		NOT NULL CHECK (role IN ('primary', 'resource_pack', 'addon')),
+		file_name		TEXT			NOT NULL,
+		download_link	TEXT			NOT NULL,
+		size			BIGINT			NOT NULL,
+		hashes			JSONB			NOT NULL,
+		downloads		INTEGER			NOT NULL DEFAULT 0,
+		creation		TIMESTAMP		NOT NULL
+	)`)
+
+	execSchema(tx, "version file index", `CREATE INDEX IF NOT EXISTS version_files_version_idx ON version_files (version)`)
+
+	// optional description of an add-on, so users can tell them apart
+	execSchema(tx, "version file label", `ALTER TABLE version_files ADD COLUMN IF NOT EXISTS label VARCHAR(100) NOT NULL DEFAULT ''`)
+
+	// hash lookups moved from the versions table to version_files, the versions indexes stay for
+	// versions whose files BackfillVersionFiles hasn't recorded yet
+	for _, algo := range HASH_ALGORITHMS {
+		execSchema(tx, "version "+algo+" index", `CREATE INDEX IF NOT EXISTS versions_`+algo+`_idx ON versions ((hashes->>'`+algo+`'))`)
+		execSchema(tx, "version resource pack "+algo+" index", `CREATE INDEX IF NOT EXISTS versions_rp_`+algo+`_idx ON versions ((rp_hashes->>'`+algo+`'))`)
+		execSchema(tx, "version file "+algo+" index", `CREATE INDEX IF NOT EXISTS version_files_`+algo+`_idx ON version_files ((hashes->>'`+algo+`'))`)
+	}
+
+	// downloads are logged and counted per file, only downloads of the primary file count for the version
+	execSchema(tx, "download event files", `ALTER TABLE download_events
+		ADD COLUMN IF NOT EXISTS file			TEXT	NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS primary_file	BOOLEAN	NOT NULL DEFAULT TRUE`)
+	execSchema(tx, "download event dedup", `ALTER TABLE download_events DROP CONSTRAINT IF EXISTS download_events_version_visitor_window_start_key`)
+	execSchema(tx, "download event dedup index", `CREATE UNIQUE INDEX IF NOT EXISTS download_events_dedup_idx ON download_events (version, file, visitor, window_start)`)
+
+	// index of what each version's datapack contains, filled in by BackfillVersionContents for older versions
+	execSchema(tx, "version contents flag", `ALTER TABLE versions ADD COLUMN IF NOT EXISTS contents_indexed BOOLEAN NOT NULL DEFAULT FALSE`)
+
+	execSchema(tx, "version namespace table", `CREATE TABLE IF NOT EXISTS version_namespaces (
+		version		TEXT		NOT NULL REFERENCES versions(id) ON DELETE CASCADE,
+		namespace	TEXT		NOT NULL,
+		functions	INTEGER		NOT NULL,
+		resources	INTEGER		NOT NULL,
+		PRIMARY KEY (version, namespace)
+	)`)
+
+	execSchema(tx, "version resource table", `CREATE TABLE IF NOT EXISTS version_resources (
+		version		TEXT				NOT NULL REFERENCES versions(id) ON DELETE CASCADE,
+		kind		VARCHAR(64)			NOT NULL,
+		location	TEXT COLLATE "C"	NOT NULL,
+		overlay		TEXT				NOT NULL DEFAULT '',
+		PRIMARY KEY (version, kind, location, overlay)
+	)`)
+
+	// content search looks resources up by location, prefix matches work as location is C collated
+	execSchema(tx, "version resource location index", `CREATE INDEX IF NOT EXISTS version_resources_location_idx ON version_resources (location)`)
+
+	// the project each namespace belongs to, claimed by its first live version. The policy decides whether
+	// uploads from other projects using the namespace are warned or blocked.
+	execSchema(tx, "namespace claim table", `CREATE TABLE IF NOT EXISTS namespace_claims (
+		namespace	TEXT			PRIMARY KEY,
+		project		TEXT			NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+		claimed		TIMESTAMP		NOT NULL,
+		policy		VARCHAR(10)		NOT NULL DEFAULT 'warn' CHECK (policy IN ('warn', 'block'))
+	)`)
+
+	execSchema(tx, "namespace claim project index", `CREATE INDEX IF NOT EXISTS namespace_claims_project_idx ON namespace_claims (project)`)
+
+	// requests of other projects for a claimed namespace, resolved by moderators
+	execSchema(tx, "namespace dispute table", `CREATE TABLE IF NOT EXISTS namespace_disputes (
+		id			TEXT			PRIMARY KEY,
+		namespace	TEXT			NOT NULL,
+		project		TEXT			NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+		reason		VARCHAR(2000)	NOT NULL,
+		status		VARCHAR(10)		NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'accepted', 'rejected')),
+		creation	TIMESTAMP		NOT NULL,
+		resolved	TIMESTAMP,
+		resolved_by	TEXT			REFERENCES users(id)
+	)`)
+
+	execSchema(tx, "namespace dispute index", `CREATE UNIQUE INDEX IF NOT EXISTS namespace_disputes_open_idx ON namespace_disputes (namespace, project) WHERE status = 'open'`)
+
 	err = tx.Commit(context.Background())
 
 	if err != nil {
@@ -124,3 +307,54 @@ func CreateTables(pg *postgres) {
 		panic(err)
 	}
 }
+
+// dedupVersionCodes renames the versions that share a version code with an older version of their project,
+// uploaded before codes had to be unique. They keep their place, their code gets the id as build metadata,
+// which SortKey ignores. Each rename is logged, so authors can be told. Once the unique index on version
+// codes exists there is nothing left to rename.
+func dedupVersionCodes(tx pgx.Tx) {
+	rows, err := tx.Query(context.Background(), `UPDATE versions SET version_code = versions.version_code || '+' || versions.id
+		FROM (
+			SELECT id, version_code, row_number() OVER (PARTITION BY project, version_code ORDER BY creation, id) AS n FROM versions
+		) numbered
+		WHERE versions.id = numbered.id AND numbered.n > 1
+		RETURNING versions.id, versions.project, numbered.version_code, versions.version_code`)
+
+	var id, project, oldCode, newCode string
+
+	if err == nil {
+		_, err = pgx.ForEachRow(rows, []any{&id, &project, &oldCode, &newCode}, func() error {
+			log.Warnf("renamed duplicate version code %s of version %s in project %s to %s\n", oldCode, id, project, newCode)
+			return nil
+		})
+	}
+
+	if err != nil {
+		newErr := tx.Rollback(context.Background())
+
+		if newErr != nil {
+			log.Errorf("failed to rollback: %v\n", err)
+			panic(err)
+		}
+
+		log.Errorf("failed to create version code dedup: %v\n", err)
+		panic(err)
+	}
+}
+
+// execSchema runs a single schema statement as part of CreateTables, giving up on startup if it fails.
+func execSchema(tx pgx.Tx, name string, statement string) {
+	_, err := tx.Exec(context.Background(), statement)
+
+	if err != nil {
+		newErr := tx.Rollback(context.Background())
+
+		if newErr != nil {
+			log.Errorf("failed to rollback: %v\n", err)
+			panic(err)
+		}
+
+		log.Errorf("failed to create %s: %v\n", name, err)
+		panic(err)
+	}
+}
@@ -0,0 +1,175 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	nanoid "github.com/matoous/go-nanoid/v2"
+)
+
+// Roles of the files of a version.
+const (
+	PrimaryFile      = "primary"       // the datapack itself, every version has exactly one
+	ResourcePackFile = "resource_pack" // the resource pack that goes with it
+	AddonFile        = "addon"         // optional extra packs, e.g. a server or client variant
+)
+
+var FILE_ROLES = []string{PrimaryFile, ResourcePackFile, AddonFile}
+
+// VersionFile is one stored file of a version.
+type VersionFile struct {
+	ID           string     `json:"id"`
+	Version      string     `json:"version"`
+	Role         string     `json:"role"`
+	FileName     string     `json:"file_name"`
+	Label        string     `json:"label"` // what an add-on is for, e.g. "server only", empty for other files
+	DownloadLink string     `json:"download_link"`
+	Size         int64      `json:"size"`
+	Hashes       FileHashes `json:"hashes"`
+	Downloads    int        `json:"downloads"`
+	Creation     time.Time  `json:"creation"`
+}
+
+// CreateVersionFiles stores the files of a new version, giving each of them an id.
+func (pg *postgres) CreateVersionFiles(tx pgx.Tx, versionId string, files []VersionFile) error {
+	for i := range files {
+		files[i].ID, _ = nanoid.New(12)
+		files[i].Version = versionId
+
+		_, err := tx.Exec(context.Background(),
+			`INSERT INTO version_files (id, version, role, file_name, label, download_link, size, hashes, creation)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+			files[i].ID,
+			files[i].Version,
+			files[i].Role,
+			files[i].FileName,
+			files[i].Label,
+			files[i].DownloadLink,
+			files[i].Size,
+			files[i].Hashes,
+			files[i].Creation)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetVersionFiles returns the files of several versions at once, keyed by version id,
+// each version's files ordered primary first.
+func (pg *postgres) GetVersionFiles(versionIds []string) (map[string][]VersionFile, error) {
+	var rows, err = pg.Db.Query(context.Background(),
+		`SELECT * FROM version_files WHERE version = ANY($1)
+			ORDER BY array_position(ARRAY['primary', 'resource_pack', 'addon']::varchar[], role), creation, id`,
+		versionIds)
+
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := pgx.CollectRows(rows, pgx.RowToStructByName[VersionFile])
+
+	if err != nil {
+		return nil, err
+	}
+
+	var byVersion = make(map[string][]VersionFile, len(versionIds))
+	for _, file := range files {
+		byVersion[file.Version] = append(byVersion[file.Version], file)
+	}
+
+	return byVersion, nil
+}
+
+// ListVersionsWithoutFiles returns the versions uploaded before their files were kept in version_files.
+func (pg *postgres) ListVersionsWithoutFiles() ([]Version, error) {
+	var rows, err = pg.Db.Query(context.Background(),
+		`SELECT * FROM versions WHERE NOT EXISTS (SELECT 1 FROM version_files WHERE version_files.version = versions.id)`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowToStructByName[Version])
+}
+
+// MainFile returns the datapack file of a version. Versions whose files haven't been recorded in
+// version_files yet are described from their own columns, without an id.
+func (version Version) MainFile() VersionFile {
+	for _, file := range version.Files {
+		if file.Role == PrimaryFile {
+			return file
+		}
+	}
+
+	var file = VersionFile{
+		Version:      version.ID,
+		Role:         PrimaryFile,
+		DownloadLink: version.DownloadLink,
+		Downloads:    version.Downloads,
+		Creation:     version.Creation,
+	}
+
+	if version.FileName != nil {
+		file.FileName = *version.FileName
+	}
+
+	if version.Hashes != nil {
+		file.Hashes = *version.Hashes
+	}
+
+	return file
+}
+
+// FileByID returns the file of a version with id, if it has one.
+func (version Version) FileByID(id string) (VersionFile, bool) {
+	for _, file := range version.Files {
+		if file.ID == id {
+			return file, true
+		}
+	}
+
+	return VersionFile{}, false
+}
+
+// DownloadURL is the route that sends the primary file of a version. Where files are stored is never
+// handed out, downloads go through the API so they are counted and drafts stay private.
+func (version Version) DownloadURL() string {
+	return "/projects/" + version.Project + "/versions/" + version.ID + "/download"
+}
+
+// FileDownloadURL is the route that sends file of a version.
+func (version Version) FileDownloadURL(file VersionFile) string {
+	return "/projects/" + version.Project + "/versions/" + version.ID + "/files/" + file.ID + "/download"
+}
+
+// MarshalJSON sends the download routes of a version and its files in place of their storage paths.
+// rp_download is only known when the files of the version were loaded.
+func (version Version) MarshalJSON() ([]byte, error) {
+	// the same fields without this method
+	type storedVersion Version
+
+	var public = storedVersion(version)
+	public.DownloadLink = version.DownloadURL()
+	public.RpDownload = nil
+
+	if version.Files != nil {
+		public.Files = make([]VersionFile, 0, len(version.Files))
+	}
+
+	for _, file := range version.Files {
+		file.DownloadLink = version.FileDownloadURL(file)
+		public.Files = append(public.Files, file)
+
+		if file.Role == ResourcePackFile {
+			var link = file.DownloadLink
+			public.RpDownload = &link
+		}
+	}
+
+	return json.Marshal(public)
+}
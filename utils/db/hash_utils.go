@@ -0,0 +1,85 @@
+package db
+
+import (
+	"context"
+	"slices"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// HASH_ALGORITHMS are the digests recorded for every stored file, by the key they have in the hashes columns.
+var HASH_ALGORITHMS = []string{"sha1", "sha256", "sha512"}
+
+var hashLengths = map[string]int{"sha1": 40, "sha256": 64, "sha512": 128}
+
+// ValidHash reports whether hash is a lowercase hex digest of the right length for algo.
+func ValidHash(algo string, hash string) bool {
+	if len(hash) != hashLengths[algo] {
+		return false
+	}
+	for _, char := range hash {
+		if !(char >= '0' && char <= '9' || char >= 'a' && char <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// Get returns the digest for algo, or "" if the algorithm isn't recorded.
+func (hashes FileHashes) Get(algo string) string {
+	switch algo {
+	case "sha1":
+		return hashes.SHA1
+	case "sha256":
+		return hashes.SHA256
+	case "sha512":
+		return hashes.SHA512
+	}
+	return ""
+}
+
+// HashMatch is a version found by the digest of one of its files.
+type HashMatch struct {
+	Version
+	MatchedHash string `json:"-"`
+	MatchedFile string `json:"-"`
+	MatchedRole string `json:"-"`
+}
+
+// GetVersionsByHash looks up the versions of live projects that have a file with one of hashes.
+// Versions whose files aren't in version_files yet are matched by their own hash columns, without a file id.
+// algo has to be one of HASH_ALGORITHMS, it is used as a JSON key in the query.
+func (pg *postgres) GetVersionsByHash(algo string, hashes []string) ([]HashMatch, error) {
+	if !slices.Contains(HASH_ALGORITHMS, algo) {
+		return nil, nil
+	}
+
+	var rows, err = pg.Db.Query(context.Background(),
+		`SELECT * FROM (
+			SELECT versions.*,
+				version_files.hashes->>'`+algo+`' AS matched_hash,
+				version_files.id AS matched_file,
+				version_files.role AS matched_role
+			FROM version_files
+			JOIN versions ON versions.id = version_files.version
+			WHERE version_files.hashes->>'`+algo+`' = ANY($1)
+		UNION ALL
+			SELECT versions.*, matched.hash, '', matched.role
+			FROM versions
+			CROSS JOIN LATERAL (VALUES
+				(versions.hashes->>'`+algo+`', 'primary'),
+				(versions.rp_hashes->>'`+algo+`', 'resource_pack')
+			) AS matched (hash, role)
+			WHERE (versions.hashes->>'`+algo+`' = ANY($1) OR versions.rp_hashes->>'`+algo+`' = ANY($1))
+				AND matched.hash = ANY($1)
+				AND NOT EXISTS (SELECT 1 FROM version_files WHERE version_files.version = versions.id)
+		) AS found
+		WHERE EXISTS (SELECT 1 FROM projects WHERE projects.id = found.project AND projects.status = 'live')
+		ORDER BY found.creation`, hashes)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowToStructByName[HashMatch])
+}
@@ -0,0 +1,74 @@
+package db
+
+import (
+	"context"
+
+	"github.com/HoodieRocks/dph-api-2/utils/datapack"
+	"github.com/jackc/pgx/v5"
+)
+
+// ! MINECRAFT VERSIONS
+
+// SeedGameVersions adds the bundled Minecraft versions to the registry,
+// versions that already exist are left alone so admin edits survive restarts.
+func (pg *postgres) SeedGameVersions(versions []datapack.GameVersion) error {
+	batch := &pgx.Batch{}
+
+	for _, version := range versions {
+		batch.Queue(`INSERT INTO minecraft_versions (id, type, data_pack_format, resource_pack_format)
+			VALUES ($1, $2, $3, $4) ON CONFLICT (id) DO NOTHING`,
+			version.ID,
+			version.Type,
+			version.DataPackFormat,
+			version.ResourcePackFormat)
+	}
+
+	return pg.Db.SendBatch(context.Background(), batch).Close()
+}
+
+// ListGameVersions returns the registry oldest first, optionally only versions of one type.
+func (pg *postgres) ListGameVersions(versionType string) ([]datapack.GameVersion, error) {
+	rows, err := pg.Db.Query(context.Background(),
+		`SELECT id, type, data_pack_format, resource_pack_format FROM minecraft_versions
+			WHERE $1 = '' OR type = $1
+			ORDER BY data_pack_format, resource_pack_format, id`,
+		versionType)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowToStructByName[datapack.GameVersion])
+}
+
+func (pg *postgres) GetGameVersion(id string) (datapack.GameVersion, error) {
+	rows, err := pg.Db.Query(context.Background(),
+		`SELECT id, type, data_pack_format, resource_pack_format FROM minecraft_versions WHERE id = $1`, id)
+
+	if err != nil {
+		return datapack.GameVersion{}, err
+	}
+
+	return pgx.CollectOneRow(rows, pgx.RowToStructByName[datapack.GameVersion])
+}
+
+// SaveGameVersion creates or replaces a version in the registry.
+func (pg *postgres) SaveGameVersion(tx pgx.Tx, version datapack.GameVersion) error {
+	_, err := tx.Exec(context.Background(),
+		`INSERT INTO minecraft_versions (id, type, data_pack_format, resource_pack_format)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (id) DO UPDATE SET
+				type = EXCLUDED.type,
+				data_pack_format = EXCLUDED.data_pack_format,
+				resource_pack_format = EXCLUDED.resource_pack_format`,
+		version.ID,
+		version.Type,
+		version.DataPackFormat,
+		version.ResourcePackFormat)
+	return err
+}
+
+func (pg *postgres) DeleteGameVersion(tx pgx.Tx, id string) error {
+	_, err := tx.Exec(context.Background(), `DELETE FROM minecraft_versions WHERE id = $1`, id)
+	return err
+}
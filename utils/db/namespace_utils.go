@@ -0,0 +1,199 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/HoodieRocks/dph-api-2/utils/datapack"
+	"github.com/jackc/pgx/v5"
+	nanoid "github.com/matoous/go-nanoid/v2"
+)
+
+// What happens to uploads of other projects that use a claimed namespace.
+const (
+	NamespaceWarn  = "warn"  // the version gets a warning
+	NamespaceBlock = "block" // the upload is rejected
+)
+
+var NAMESPACE_POLICIES = []string{NamespaceWarn, NamespaceBlock}
+
+// States of a namespace dispute.
+const (
+	DisputeOpen     = "open"
+	DisputeAccepted = "accepted" // the namespace was transferred to the disputing project
+	DisputeRejected = "rejected"
+)
+
+// NamespaceClaim is the project a namespace belongs to.
+type NamespaceClaim struct {
+	Namespace string    `json:"namespace"`
+	Project   string    `json:"project,omitempty"` // left out by getNamespace while the project isn't live
+	Claimed   time.Time `json:"claimed"` // when the current project got it
+	Policy    string    `json:"policy"`  // one of NAMESPACE_POLICIES
+}
+
+// NamespaceDispute is a project asking moderators for a namespace another project claimed.
+type NamespaceDispute struct {
+	ID         string     `json:"id"`
+	Namespace  string     `json:"namespace"`
+	Project    string     `json:"project"`
+	Reason     string     `json:"reason"`
+	Status     string     `json:"status"`
+	Creation   time.Time  `json:"creation"`
+	Resolved   *time.Time `json:"resolved"`
+	ResolvedBy *string    `json:"resolved_by"`
+}
+
+// ClaimNamespaces gives the namespaces nobody claimed yet to a project. Shared namespaces are never claimed.
+func (pg *postgres) ClaimNamespaces(tx pgx.Tx, projectId string, namespaces []string) error {
+	_, err := tx.Exec(context.Background(),
+		`INSERT INTO namespace_claims (namespace, project, claimed)
+			SELECT namespace, $1, NOW() FROM unnest($2::text[]) AS namespace
+			WHERE namespace <> ALL($3)
+			ON CONFLICT (namespace) DO NOTHING`,
+		projectId, namespaces, datapack.SharedNamespaces)
+
+	return err
+}
+
+// ClaimProjectNamespaces claims every namespace the versions of a project use, for when the project goes live.
+func (pg *postgres) ClaimProjectNamespaces(tx pgx.Tx, projectId string) error {
+	_, err := tx.Exec(context.Background(),
+		`INSERT INTO namespace_claims (namespace, project, claimed)
+			SELECT DISTINCT version_namespaces.namespace, $1, NOW() FROM version_namespaces
+			JOIN versions ON versions.id = version_namespaces.version
+			WHERE versions.project = $1 AND version_namespaces.namespace <> ALL($2)
+			ON CONFLICT (namespace) DO NOTHING`,
+		projectId, datapack.SharedNamespaces)
+
+	return err
+}
+
+// BackfillNamespaceClaims claims the namespaces of live projects from before namespaces were claimed.
+// A namespace goes to the project with the oldest version using it.
+func (pg *postgres) BackfillNamespaceClaims() error {
+	_, err := pg.Db.Exec(context.Background(),
+		`INSERT INTO namespace_claims (namespace, project, claimed)
+			SELECT DISTINCT ON (version_namespaces.namespace)
+				version_namespaces.namespace, versions.project, versions.creation
+			FROM version_namespaces
+			JOIN versions ON versions.id = version_namespaces.version
+			JOIN projects ON projects.id = versions.project
+			WHERE projects.status = 'live' AND version_namespaces.namespace <> ALL($1)
+			ORDER BY version_namespaces.namespace, versions.creation, versions.id
+			ON CONFLICT (namespace) DO NOTHING`,
+		datapack.SharedNamespaces)
+
+	return err
+}
+
+// GetNamespaceClaim returns the claim on a namespace, pgx.ErrNoRows if nobody claimed it.
+func (pg *postgres) GetNamespaceClaim(namespace string) (NamespaceClaim, error) {
+	var rows, err = pg.Db.Query(context.Background(), `SELECT * FROM namespace_claims WHERE namespace = $1`, namespace)
+
+	if err != nil {
+		return NamespaceClaim{}, err
+	}
+
+	return pgx.CollectOneRow(rows, pgx.RowToStructByName[NamespaceClaim])
+}
+
+// ListNamespaceClaims returns the claims on the namespaces, unclaimed ones are left out.
+func (pg *postgres) ListNamespaceClaims(namespaces []string) ([]NamespaceClaim, error) {
+	var rows, err = pg.Db.Query(context.Background(),
+		`SELECT * FROM namespace_claims WHERE namespace = ANY($1) ORDER BY namespace`, namespaces)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowToStructByName[NamespaceClaim])
+}
+
+// TransferNamespace gives a namespace to a project, claiming it if nobody has. The policy is kept.
+func (pg *postgres) TransferNamespace(tx pgx.Tx, namespace string, projectId string) error {
+	_, err := tx.Exec(context.Background(),
+		`INSERT INTO namespace_claims (namespace, project, claimed) VALUES ($1, $2, NOW())
+			ON CONFLICT (namespace) DO UPDATE SET project = EXCLUDED.project, claimed = EXCLUDED.claimed`,
+		namespace, projectId)
+
+	return err
+}
+
+func (pg *postgres) SetNamespacePolicy(tx pgx.Tx, namespace string, policy string) error {
+	_, err := tx.Exec(context.Background(), `UPDATE namespace_claims SET policy = $1 WHERE namespace = $2`, policy, namespace)
+	return err
+}
+
+// ReleaseNamespace drops the claim on a namespace, the next live version using it claims it again.
+func (pg *postgres) ReleaseNamespace(tx pgx.Tx, namespace string) error {
+	_, err := tx.Exec(context.Background(), `DELETE FROM namespace_claims WHERE namespace = $1`, namespace)
+	return err
+}
+
+// CreateNamespaceDispute opens a dispute and returns its id.
+func (pg *postgres) CreateNamespaceDispute(tx pgx.Tx, dispute NamespaceDispute) (string, error) {
+	var id, err = nanoid.New(12)
+
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tx.Exec(context.Background(),
+		`INSERT INTO namespace_disputes (id, namespace, project, reason, status, creation) VALUES ($1, $2, $3, $4, $5, $6)`,
+		id, dispute.Namespace, dispute.Project, dispute.Reason, DisputeOpen, dispute.Creation)
+
+	return id, err
+}
+
+func (pg *postgres) GetNamespaceDispute(id string) (NamespaceDispute, error) {
+	var rows, err = pg.Db.Query(context.Background(), `SELECT * FROM namespace_disputes WHERE id = $1`, id)
+
+	if err != nil {
+		return NamespaceDispute{}, err
+	}
+
+	return pgx.CollectOneRow(rows, pgx.RowToStructByName[NamespaceDispute])
+}
+
+// HasOpenNamespaceDispute reports whether a project already disputes a namespace.
+func (pg *postgres) HasOpenNamespaceDispute(namespace string, projectId string) (bool, error) {
+	var exists bool
+	var err = pg.Db.QueryRow(context.Background(),
+		`SELECT EXISTS (SELECT 1 FROM namespace_disputes WHERE namespace = $1 AND project = $2 AND status = 'open')`,
+		namespace, projectId).Scan(&exists)
+
+	return exists, err
+}
+
+// ListOpenNamespaceDisputes returns the disputes moderators still have to resolve, oldest first.
+func (pg *postgres) ListOpenNamespaceDisputes() ([]NamespaceDispute, error) {
+	var rows, err = pg.Db.Query(context.Background(),
+		`SELECT * FROM namespace_disputes WHERE status = 'open' ORDER BY creation, id`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowToStructByName[NamespaceDispute])
+}
+
+// ResolveNamespaceDispute closes a dispute, recording the moderator who did.
+func (pg *postgres) ResolveNamespaceDispute(tx pgx.Tx, id string, status string, moderatorId string) error {
+	_, err := tx.Exec(context.Background(),
+		`UPDATE namespace_disputes SET status = $1, resolved = NOW(), resolved_by = $2 WHERE id = $3`,
+		status, moderatorId, id)
+
+	return err
+}
+
+// CloseNamespaceDisputes closes the open disputes for a namespace that was just given to a project. The
+// dispute of that project is accepted, the others are rejected, all recorded as resolved by userId.
+func (pg *postgres) CloseNamespaceDisputes(tx pgx.Tx, namespace string, projectId string, userId string) error {
+	_, err := tx.Exec(context.Background(),
+		`UPDATE namespace_disputes SET status = CASE WHEN project = $2 THEN $3 ELSE $4 END, resolved = NOW(), resolved_by = $5
+			WHERE namespace = $1 AND status = $6`,
+		namespace, projectId, DisputeAccepted, DisputeRejected, userId, DisputeOpen)
+
+	return err
+}
@@ -0,0 +1,128 @@
+package db
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/HoodieRocks/dph-api-2/utils/paging"
+)
+
+// ProjectSort describes a whitelisted ordering for project listings.
+// Expr is trusted SQL and must never be built from user input,
+// Type is the Postgres type of Expr, used to read sort keys back out of cursors.
+type ProjectSort struct {
+	Name string
+	Expr string
+	Type string
+	Desc bool
+}
+
+// PROJECT_SORTS maps the public sort names onto their SQL expressions.
+// Every sort is tie-broken on id so that paging is stable.
+var PROJECT_SORTS = map[string]ProjectSort{
+	"downloads": {Expr: "downloads", Type: "integer"},
+	"newest":    {Expr: "creation", Type: "timestamp"},
+	"updated":   {Expr: "updated", Type: "timestamp"},
+	"title":     {Expr: "LOWER(title)", Type: "text"},
+	// downloads per day of age, with extra gravity so old packs fall off the list.
+	// The score drifts with NOW(), so trending cursors are best-effort.
+	"trending": {Expr: "downloads / POWER(EXTRACT(EPOCH FROM (NOW() - creation)) / 86400 + 2, 1.5)", Type: "double precision"},
+}
+
+const DefaultProjectSort = "downloads"
+
+// GetProjectSort looks up a sort by name and direction, the bool is false if the name is not whitelisted.
+func GetProjectSort(name string, desc bool) (ProjectSort, bool) {
+	sort, ok := PROJECT_SORTS[name]
+	sort.Name = name
+	sort.Desc = desc
+	return sort, ok
+}
+
+// ProjectFilter narrows down the live projects returned by a listing.
+// Values inside a single field are OR'ed, separate fields are AND'ed.
+type ProjectFilter struct {
+	Categories []string
+	Versions   []string
+	Licenses   []string
+	Authors    []string
+}
+
+// queryBuilder collects WHERE conditions and their positional arguments.
+type queryBuilder struct {
+	conds []string
+	args  []any
+}
+
+// arg registers a bind parameter and returns its placeholder.
+func (qb *queryBuilder) arg(value any) string {
+	qb.args = append(qb.args, value)
+	return "$" + strconv.Itoa(len(qb.args))
+}
+
+func (qb *queryBuilder) where(cond string) {
+	qb.conds = append(qb.conds, cond)
+}
+
+func (qb *queryBuilder) whereClause() string {
+	if len(qb.conds) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(qb.conds, " AND ")
+}
+
+// applyProjectFilter adds the filter conditions for the projects table.
+func (qb *queryBuilder) applyProjectFilter(filter ProjectFilter) {
+	qb.where("status = 'live'")
+
+	if len(filter.Categories) > 0 {
+		qb.where("category && " + qb.arg(filter.Categories))
+	}
+	if len(filter.Versions) > 0 {
+		qb.where(`EXISTS (
+			SELECT 1 FROM versions WHERE versions.project = projects.id AND versions.supports && ` + qb.arg(filter.Versions) + `
+		)`)
+	}
+	if len(filter.Licenses) > 0 {
+		qb.where("license = ANY(" + qb.arg(filter.Licenses) + ")")
+	}
+	if len(filter.Authors) > 0 {
+		qb.where("author = ANY(" + qb.arg(filter.Authors) + ")")
+	}
+}
+
+// keyset pages a query ordered by (expr, id). It adds the cursor condition, so it must be called
+// before whereClause, and returns the ORDER BY and LIMIT clauses. One row more than the limit is
+// fetched so paging.NewPage can tell whether another page follows.
+func (qb *queryBuilder) keyset(expr string, typ string, desc bool, req paging.Request) string {
+	var backward = req.Cursor != nil && req.Cursor.Backward
+
+	// walking backwards flips the direction, paging.NewPage puts the rows back in order
+	var descending = desc != backward
+
+	var direction, comparison = " ASC", " > "
+	if descending {
+		direction, comparison = " DESC", " < "
+	}
+
+	if req.Cursor != nil {
+		qb.where("(" + expr + ", id)" + comparison + "(" + qb.arg(req.Cursor.Key) + "::" + typ + ", " + qb.arg(req.Cursor.ID) + ")")
+	}
+
+	var clause = " ORDER BY " + expr + direction + ", id" + direction + " LIMIT " + qb.arg(req.Limit+1)
+	if req.Cursor == nil && req.Offset > 0 {
+		clause += " OFFSET " + qb.arg(req.Offset)
+	}
+
+	return clause
+}
+
+// keyset pages a project query with this sort.
+func (sort ProjectSort) keyset(qb *queryBuilder, req paging.Request) string {
+	return qb.keyset(sort.Expr, sort.Type, sort.Desc, req)
+}
+
+// sortKeyColumn selects expr as text, for building cursors from the returned rows.
+func sortKeyColumn(expr string) string {
+	return ", (" + expr + ")::text AS sort_key"
+}
@@ -5,6 +5,8 @@ import (
 	"strings"
 	"time"
 
+	"github.com/HoodieRocks/dph-api-2/utils/markdown"
+	"github.com/HoodieRocks/dph-api-2/utils/paging"
 	"github.com/jackc/pgx/v5"
 	nanoid "github.com/matoous/go-nanoid/v2"
 )
@@ -15,6 +17,8 @@ var PROJECT_COLUMNS = `id,
 			author,
 			description,
 			body,
+			body_html,
+			body_renderer,
 			creation,
 			updated,
 			status,
@@ -26,29 +30,31 @@ var PROJECT_COLUMNS = `id,
 
 // ! PROJECTS
 
-func (pg *postgres) ListProjects(limit int, offset int, orderBy string) ([]Project, error) {
+func (pg *postgres) ListProjects(filter ProjectFilter, sort ProjectSort, req paging.Request) ([]ProjectRow, error) {
+	var qb queryBuilder
+	qb.applyProjectFilter(filter)
+	var page = sort.keyset(&qb, req)
+
 	rows, err := pg.Db.Query(context.Background(),
-		`SELECT `+PROJECT_COLUMNS+`
-			FROM projects
-			WHERE status = 'live'
-			ORDER BY $1 ASC
-			LIMIT $2 OFFSET $3`,
-		orderBy,
-		limit,
-		offset)
+		`SELECT `+PROJECT_COLUMNS+sortKeyColumn(sort.Expr)+`
+			FROM projects`+qb.whereClause()+page,
+		qb.args...)
 
 	if err != nil {
-
-		if err == pgx.ErrNoRows {
-			return []Project{}, nil
-		}
-
 		return nil, err
 	}
 
-	projects, err := pgx.CollectRows(rows, pgx.RowToStructByName[Project])
+	return pgx.CollectRows(rows, pgx.RowToStructByName[ProjectRow])
+}
 
-	return projects, err
+func (pg *postgres) CountProjects(filter ProjectFilter) (int, error) {
+	var qb queryBuilder
+	qb.applyProjectFilter(filter)
+
+	var total = 0
+	var err = pg.Db.QueryRow(context.Background(), `SELECT count(*) FROM projects`+qb.whereClause(), qb.args...).Scan(&total)
+
+	return total, err
 }
 
 // TODO security hotspot - column is not safe (if ever allowed to handle user input)
@@ -74,17 +80,41 @@ func (pg *postgres) GetProjectBySlug(slug string) (Project, error) {
 	return pg.getProjectByX("slug", slug)
 }
 
-func (pg *postgres) GetProjectByStatus(status string, limit int, offset int) ([]Project, error) {
-	var project []Project
-	var row, err = pg.Db.Query(context.Background(), `SELECT `+PROJECT_COLUMNS+` FROM projects WHERE status = '$1' ORDER BY id LIMIT $2 OFFSET $3`, status, limit, offset)
+// GetProjectsByIDs fetches several projects at once, keyed by id. Unknown ids are left out.
+func (pg *postgres) GetProjectsByIDs(ids []string) (map[string]Project, error) {
+	var row, err = pg.Db.Query(context.Background(), `SELECT `+PROJECT_COLUMNS+` FROM projects WHERE id = ANY($1)`, ids)
 
 	if err != nil {
-		return project, err
+		return nil, err
 	}
 
-	project, err = pgx.CollectRows(row, pgx.RowToStructByName[Project])
+	projects, err := pgx.CollectRows(row, pgx.RowToStructByName[Project])
 
-	return project, err
+	if err != nil {
+		return nil, err
+	}
+
+	var byID = make(map[string]Project, len(projects))
+	for _, project := range projects {
+		byID[project.ID] = project
+	}
+
+	return byID, nil
+}
+
+// GetProjectByStatus pages through projects in a status, oldest first so review queues are worked in order.
+func (pg *postgres) GetProjectByStatus(status string, req paging.Request) ([]ProjectRow, error) {
+	var qb queryBuilder
+	qb.where("status = " + qb.arg(status))
+	var page = qb.keyset("creation", "timestamp", false, req)
+
+	var rows, err = pg.Db.Query(context.Background(), `SELECT `+PROJECT_COLUMNS+sortKeyColumn("creation")+` FROM projects`+qb.whereClause()+page, qb.args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowToStructByName[ProjectRow])
 }
 
 func (pg *postgres) GetRandomProjects(limit int) ([]Project, error) {
@@ -101,33 +131,19 @@ func (pg *postgres) GetRandomProjects(limit int) ([]Project, error) {
 	return project, err
 }
 
-func (pg *postgres) GetAllProjectsByAuthor(authorId string) ([]Project, error) {
-	var project []Project
+// GetProjectsByAuthor pages through the projects of an author, newest first.
+func (pg *postgres) GetProjectsByAuthor(authorId string, req paging.Request) ([]ProjectRow, error) {
+	var qb queryBuilder
+	qb.where("author = " + qb.arg(authorId))
+	var page = qb.keyset("creation", "timestamp", true, req)
 
-	var rows, err = pg.Db.Query(context.Background(), `SELECT `+PROJECT_COLUMNS+` FROM projects WHERE author = $1`, authorId)
+	var rows, err = pg.Db.Query(context.Background(), `SELECT `+PROJECT_COLUMNS+sortKeyColumn("creation")+` FROM projects`+qb.whereClause()+page, qb.args...)
 
 	if err != nil {
-		return project, err
+		return nil, err
 	}
 
-	project, err = pgx.CollectRows(rows, pgx.RowToStructByName[Project])
-
-	return project, err
-}
-
-func (pg *postgres) FTSSearchProjects(query string) (pgx.Rows, error) {
-	var rows, err = pg.Db.Query(context.Background(), `SELECT `+PROJECT_COLUMNS+` FROM projects WHERE fts_column @@ to_tsquery('english',$1) AND status = 'live' LIMIT 100`, query)
-	return rows, err
-}
-
-func (pg *postgres) SearchProjects(query string) (pgx.Rows, error) {
-	var rows, err = pg.Db.Query(context.Background(), `SELECT `+PROJECT_COLUMNS+` FROM projects WHERE 
-		(
-			title LIKE $1 OR 
-			description LIKE $1 OR 
-			slug LIKE $1
-		) AND status = 'live' LIMIT 100`, "%"+query+"%")
-	return rows, err
+	return pgx.CollectRows(rows, pgx.RowToStructByName[ProjectRow])
 }
 
 func (pg *postgres) CheckForProjectNameConflict(title string, slug string) bool {
@@ -143,13 +159,15 @@ func (pg *postgres) CreateProject(tx pgx.Tx, project Project) error {
 	id, _ := nanoid.New(12)
 
 	_, err := tx.Exec(context.Background(),
-		"INSERT INTO projects (id, title, slug, author, description, body, creation, updated, category) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)",
+		"INSERT INTO projects (id, title, slug, author, description, body, body_html, body_renderer, creation, updated, category) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)",
 		id,
 		project.Title,
 		project.Slug,
 		project.Author,
 		project.Description,
 		project.Body,
+		project.BodyHTML,
+		project.BodyRenderer,
 		project.Creation,
 		project.Updated,
 		project.Category)
@@ -170,8 +188,10 @@ func (pg *postgres) UpdateProject(tx pgx.Tx, project Project) error {
 		slug = $10,
 		status = $11,
 		title = $12,
-		updated = $13
-	WHERE id = $14`
+		updated = $13,
+		body_html = $14,
+		body_renderer = $15
+	WHERE id = $16`
 
 	params := []interface{}{
 		project.Author,
@@ -187,6 +207,8 @@ func (pg *postgres) UpdateProject(tx pgx.Tx, project Project) error {
 		project.Status,
 		project.Title,
 		project.Updated,
+		project.BodyHTML,
+		project.BodyRenderer,
 		project.ID,
 	}
 
@@ -194,8 +216,55 @@ func (pg *postgres) UpdateProject(tx pgx.Tx, project Project) error {
 	return err
 }
 
-func (pg *postgres) UpdateProjectDownloads(tx pgx.Tx, projectId string, downloads int) error {
-	_, err := tx.Exec(context.Background(), `UPDATE projects SET downloads = $1 WHERE id = $2`, downloads, projectId)
+// SetBody sets the body of a project along with its rendered HTML.
+func (project *Project) SetBody(body string) {
+	project.Body = body
+	project.BodyHTML = markdown.Render(body)
+	project.BodyRenderer = markdown.RendererKey
+}
+
+// BackfillProjectBodyHTML renders the bodies that were rendered by an older or differently configured
+// renderer, or not at all. A body edited while it runs already has its new rendering and is left alone.
+func (pg *postgres) BackfillProjectBodyHTML() error {
+	rows, err := pg.Db.Query(context.Background(), `SELECT id, body FROM projects WHERE body_renderer <> $1`, markdown.RendererKey)
+
+	if err != nil {
+		return err
+	}
+
+	batch := &pgx.Batch{}
+
+	var id, body string
+	_, err = pgx.ForEachRow(rows, []any{&id, &body}, func() error {
+		batch.Queue(`UPDATE projects SET body_html = $1, body_renderer = $2
+				WHERE id = $3 AND body = $4 AND body_renderer <> $2`,
+			markdown.Render(body), markdown.RendererKey, id, body)
+		return nil
+	})
+
+	if err != nil || batch.Len() == 0 {
+		return err
+	}
+
+	return pg.Db.SendBatch(context.Background(), batch).Close()
+}
+
+// CountDownload adds a download to a version and its project. The counters are incremented in the database,
+// so concurrent downloads are never lost.
+func (pg *postgres) CountDownload(tx pgx.Tx, versionId string, projectId string) error {
+	_, err := tx.Exec(context.Background(), `UPDATE versions SET downloads = downloads + 1 WHERE id = $1`, versionId)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(context.Background(), `UPDATE projects SET downloads = downloads + 1 WHERE id = $1`, projectId)
+	return err
+}
+
+// TouchProject bumps the updated time of a project, e.g. when one of its versions changes.
+func (pg *postgres) TouchProject(tx pgx.Tx, id string) error {
+	_, err := tx.Exec(context.Background(), `UPDATE projects SET updated = NOW() WHERE id = $1`, id)
 	return err
 }
 
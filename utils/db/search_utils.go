@@ -0,0 +1,232 @@
+package db
+
+import (
+	"context"
+	"strings"
+
+	"github.com/HoodieRocks/dph-api-2/utils/paging"
+	"github.com/jackc/pgx/v5"
+)
+
+// SearchResult is a project matched by a search, with how well it matched.
+type SearchResult struct {
+	Project
+	Rank     float64 `json:"rank"`
+	Headline string  `json:"headline,omitempty"` // HTML-escaped excerpt, matches are wrapped in <mark>
+	SortKey  string  `json:"-"`
+}
+
+func (row SearchResult) CursorKey() (string, string) {
+	return row.SortKey, row.ID
+}
+
+// FTS_RELEVANCE orders full-text matches by cover density, q is the query joined in by ftsFrom.
+var FTS_RELEVANCE = ProjectSort{Name: "relevance", Expr: "ts_rank_cd(fts_column, q)", Type: "real", Desc: true}
+
+// the excerpt is escaped before highlighting so the only markup in it is our own
+const ftsHeadline = `ts_headline('english',
+		replace(replace(replace(description || ' ' || body, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'),
+		q, 'StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MaxWords=30, MinWords=10')`
+
+// ftsFrom parses the user's query and restricts the query to live projects matching it and the filter.
+// websearch_to_tsquery accepts any input, so malformed queries can't make the search fail.
+func (qb *queryBuilder) ftsFrom(query string, filter ProjectFilter) string {
+	var from = ` FROM projects, websearch_to_tsquery('english', ` + qb.arg(query) + `) q`
+	qb.where("fts_column @@ q")
+	qb.applyProjectFilter(filter)
+	return from
+}
+
+func (pg *postgres) FTSSearchProjects(query string, filter ProjectFilter, sort ProjectSort, req paging.Request) ([]SearchResult, error) {
+	var qb queryBuilder
+	var from = qb.ftsFrom(query, filter)
+	var page = sort.keyset(&qb, req)
+
+	var rows, err = pg.Db.Query(context.Background(),
+		`SELECT `+PROJECT_COLUMNS+`,
+			ts_rank_cd(fts_column, q)::float8 AS rank,
+			`+ftsHeadline+` AS headline`+
+			sortKeyColumn(sort.Expr)+
+			from+qb.whereClause()+page,
+		qb.args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowToStructByName[SearchResult])
+}
+
+func (pg *postgres) CountFTSSearchProjects(query string, filter ProjectFilter) (int, error) {
+	var qb queryBuilder
+	var from = qb.ftsFrom(query, filter)
+
+	var total = 0
+	var err = pg.Db.QueryRow(context.Background(), `SELECT count(*)`+from+qb.whereClause(), qb.args...).Scan(&total)
+
+	return total, err
+}
+
+// TRIGRAM_RELEVANCE orders fuzzy matches by their closest trigram similarity, s is joined in by trigramFrom.
+var TRIGRAM_RELEVANCE = ProjectSort{
+	Name: "relevance",
+	Expr: "GREATEST(similarity(title, s.term), word_similarity(s.term, title), similarity(slug, s.term))",
+	Type: "real",
+	Desc: true,
+}
+
+// escapeLike escapes the LIKE wildcards in user input, so they match literally.
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
+
+// trigramFrom restricts the query to live projects that are close to the query or contain it, and match the filter.
+func (qb *queryBuilder) trigramFrom(query string, filter ProjectFilter) string {
+	var from = ` FROM projects, (SELECT ` + qb.arg(query) + `::text AS term, ` + qb.arg("%"+escapeLike(query)+"%") + `::text AS pattern) s`
+	qb.where(`(
+			title % s.term OR
+			s.term <% title OR
+			slug % s.term OR
+			title ILIKE s.pattern OR
+			slug ILIKE s.pattern OR
+			description ILIKE s.pattern
+		)`)
+	qb.applyProjectFilter(filter)
+	return from
+}
+
+func (pg *postgres) SearchProjects(query string, filter ProjectFilter, sort ProjectSort, req paging.Request) ([]SearchResult, error) {
+	var qb queryBuilder
+	var from = qb.trigramFrom(query, filter)
+	var page = sort.keyset(&qb, req)
+
+	var rows, err = pg.Db.Query(context.Background(),
+		`SELECT `+PROJECT_COLUMNS+`,
+			(`+TRIGRAM_RELEVANCE.Expr+`)::float8 AS rank,
+			'' AS headline`+
+			sortKeyColumn(sort.Expr)+
+			from+qb.whereClause()+page,
+		qb.args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowToStructByName[SearchResult])
+}
+
+func (pg *postgres) CountSearchProjects(query string, filter ProjectFilter) (int, error) {
+	var qb queryBuilder
+	var from = qb.trigramFrom(query, filter)
+
+	var total = 0
+	var err = pg.Db.QueryRow(context.Background(), `SELECT count(*)`+from+qb.whereClause(), qb.args...).Scan(&total)
+
+	return total, err
+}
+
+// Suggestion is a lightweight project match for autocomplete.
+type Suggestion struct {
+	Title string  `json:"title"`
+	Slug  string  `json:"slug"`
+	Icon  *string `json:"icon"`
+}
+
+// SuggestProjects completes a partially typed title, prefix matches come first and near misses
+// from typos fill up the rest. Only indexed lookups are used so this is cheap enough per keystroke.
+func (pg *postgres) SuggestProjects(prefix string, limit int) ([]Suggestion, error) {
+	var rows, err = pg.Db.Query(context.Background(),
+		`SELECT title, slug, icon
+			FROM projects
+			WHERE status = 'live' AND (
+				LOWER(title) LIKE $1 OR
+				slug LIKE $1 OR
+				title % $2
+			)
+			ORDER BY LOWER(title) LIKE $1 DESC, similarity(title, $2) DESC, downloads DESC
+			LIMIT $3`,
+		strings.ToLower(escapeLike(prefix))+"%",
+		prefix,
+		limit)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowToStructByName[Suggestion])
+}
+
+// FacetCount is the number of matching projects with a given facet value.
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// Facets break a search's full match set down by the values users can filter on.
+type Facets struct {
+	Category []FacetCount `json:"category"`
+	Version  []FacetCount `json:"version"`
+	License  []FacetCount `json:"license"`
+}
+
+const MaxFacetValues = 50
+
+// searchFrom restricts a query to the matches of a search, see ftsFrom and trigramFrom.
+type searchFrom func(qb *queryBuilder, query string, filter ProjectFilter) string
+
+// facetCounts counts the matches per value of one facet. join must expose the facet as f.value.
+func (pg *postgres) facetCounts(from searchFrom, query string, filter ProjectFilter, join string) ([]FacetCount, error) {
+	var qb queryBuilder
+	var fromClause = from(&qb, query, filter)
+
+	var rows, err = pg.Db.Query(context.Background(),
+		`SELECT f.value AS value, count(DISTINCT id) AS count`+fromClause+join+qb.whereClause()+`
+			GROUP BY f.value
+			ORDER BY count DESC, value
+			LIMIT `+qb.arg(MaxFacetValues),
+		qb.args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowToStructByName[FacetCount])
+}
+
+// searchFacets counts a search's matches per category, supported version and license.
+// Each facet ignores its own filter, so a selected value doesn't hide its alternatives.
+func (pg *postgres) searchFacets(from searchFrom, query string, filter ProjectFilter) (Facets, error) {
+	var facets Facets
+	var err error
+
+	var withoutCategories = filter
+	withoutCategories.Categories = nil
+	facets.Category, err = pg.facetCounts(from, query, withoutCategories,
+		`, unnest(category) AS f(value)`)
+	if err != nil {
+		return facets, err
+	}
+
+	var withoutVersions = filter
+	withoutVersions.Versions = nil
+	facets.Version, err = pg.facetCounts(from, query, withoutVersions,
+		`, LATERAL (SELECT DISTINCT unnest(versions.supports) AS value FROM versions WHERE versions.project = projects.id) AS f`)
+	if err != nil {
+		return facets, err
+	}
+
+	var withoutLicenses = filter
+	withoutLicenses.Licenses = nil
+	facets.License, err = pg.facetCounts(from, query, withoutLicenses,
+		`, LATERAL (SELECT license AS value WHERE license IS NOT NULL) AS f`)
+
+	return facets, err
+}
+
+func (pg *postgres) FTSSearchFacets(query string, filter ProjectFilter) (Facets, error) {
+	return pg.searchFacets((*queryBuilder).ftsFrom, query, filter)
+}
+
+func (pg *postgres) SearchFacets(query string, filter ProjectFilter) (Facets, error) {
+	return pg.searchFacets((*queryBuilder).trigramFrom, query, filter)
+}
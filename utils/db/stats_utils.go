@@ -0,0 +1,181 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// DownloadEvent is a single counted download. Visitor is a salted hash that only identifies the same
+// client within a day, Window is the start of the dedup window the download fell in.
+// File is empty for versions whose files aren't in version_files yet, and only downloads of the
+// primary file count towards the version and project.
+type DownloadEvent struct {
+	Version  string
+	Project  string
+	File     string
+	Primary  bool
+	Day      time.Time
+	Window   time.Time
+	Client   string
+	Referrer string
+	Visitor  string
+}
+
+// RecordDownload logs a single download event as part of tx, and reports false if the visitor already
+// downloaded the version in the same window, in which case nothing is logged. Downloads are normally
+// written in batches with FlushDownloads instead.
+func (pg *postgres) RecordDownload(tx pgx.Tx, event DownloadEvent) (bool, error) {
+	tag, err := tx.Exec(context.Background(),
+		`INSERT INTO download_events (version, project, file, primary_file, day, window_start, client, referrer, visitor)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			ON CONFLICT (version, file, visitor, window_start) DO NOTHING`,
+		event.Version,
+		event.Project,
+		event.File,
+		event.Primary,
+		event.Day,
+		event.Window,
+		event.Client,
+		event.Referrer,
+		event.Visitor)
+
+	if err != nil {
+		return false, err
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+// FlushDownloads logs a batch of download events and counts the ones that aren't repeats on their file,
+// version and project, in a single statement. It returns how many downloads were counted.
+func (pg *postgres) FlushDownloads(events []DownloadEvent) (int64, error) {
+	var versions, projects, files, clients, referrers, visitors []string
+	var primaries []bool
+	var days, windows []time.Time
+
+	for _, event := range events {
+		versions = append(versions, event.Version)
+		projects = append(projects, event.Project)
+		files = append(files, event.File)
+		primaries = append(primaries, event.Primary)
+		days = append(days, event.Day)
+		windows = append(windows, event.Window)
+		clients = append(clients, event.Client)
+		referrers = append(referrers, event.Referrer)
+		visitors = append(visitors, event.Visitor)
+	}
+
+	// only the events that were actually inserted come out of the first step, so dedup applies to the counters too
+	var counted int64
+	err := pg.Db.QueryRow(context.Background(),
+		`WITH inserted AS (
+			INSERT INTO download_events (version, project, file, primary_file, day, window_start, client, referrer, visitor)
+				SELECT * FROM unnest($1::text[], $2::text[], $3::text[], $4::boolean[], $5::date[], $6::timestamp[], $7::text[], $8::text[], $9::text[])
+				ON CONFLICT (version, file, visitor, window_start) DO NOTHING
+				RETURNING version, project, file, primary_file
+		), file_counts AS (
+			UPDATE version_files SET downloads = version_files.downloads + counts.downloads
+				FROM (SELECT file, COUNT(*) AS downloads FROM inserted WHERE file <> '' GROUP BY file) counts
+				WHERE version_files.id = counts.file
+		), version_counts AS (
+			UPDATE versions SET downloads = versions.downloads + counts.downloads
+				FROM (SELECT version, COUNT(*) AS downloads FROM inserted WHERE primary_file GROUP BY version) counts
+				WHERE versions.id = counts.version
+		), project_counts AS (
+			UPDATE projects SET downloads = projects.downloads + counts.downloads
+				FROM (SELECT project, COUNT(*) AS downloads FROM inserted WHERE primary_file GROUP BY project) counts
+				WHERE projects.id = counts.project
+		)
+		SELECT COUNT(*) FROM inserted`,
+		versions, projects, files, primaries, days, windows, clients, referrers, visitors).Scan(&counted)
+
+	return counted, err
+}
+
+// GetDownloadSalt returns the salt visitor hashes are made with on day, creating it on first use.
+// Salts are deleted by RollupDownloads once their day is over, so old hashes can't be linked to anyone.
+func (pg *postgres) GetDownloadSalt(day time.Time) ([]byte, error) {
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	// another instance may have made the salt first, so always read back the one that was kept
+	_, err := pg.Db.Exec(context.Background(),
+		`INSERT INTO download_salts (day, salt) VALUES ($1, $2) ON CONFLICT (day) DO NOTHING`, day, salt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = pg.Db.QueryRow(context.Background(), `SELECT salt FROM download_salts WHERE day = $1`, day).Scan(&salt)
+	return salt, err
+}
+
+// RollupDownloads counts the logged download events into the daily stats, then deletes events older than
+// retention days and salts of past days. Days still in the event log are recounted from scratch,
+// so running it again, or from several instances, is harmless.
+func (pg *postgres) RollupDownloads(retention int) error {
+	tx, err := pg.Db.Begin(context.Background())
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback(context.Background())
+
+	_, err = tx.Exec(context.Background(),
+		`INSERT INTO download_stats (project, version, day, client, referrer, downloads)
+			SELECT project, version, day, client, referrer, COUNT(*) FROM download_events
+			WHERE primary_file
+			GROUP BY project, version, day, client, referrer
+			ON CONFLICT (version, day, client, referrer) DO UPDATE SET downloads = EXCLUDED.downloads`)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(context.Background(), `DELETE FROM download_events WHERE day < (NOW() AT TIME ZONE 'UTC')::date - $1::integer`, retention)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(context.Background(), `DELETE FROM download_salts WHERE day < (NOW() AT TIME ZONE 'UTC')::date`)
+
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(context.Background())
+}
+
+// DailyDownloads is the number of downloads of a version on a day from one kind of client and referrer.
+// VersionCode is nil once the version has been deleted.
+type DailyDownloads struct {
+	Day         time.Time `json:"day"`
+	Version     string    `json:"version"`
+	VersionCode *string   `json:"version_code"`
+	Client      string    `json:"client"`
+	Referrer    string    `json:"referrer"`
+	Downloads   int       `json:"downloads"`
+}
+
+// GetDownloadStats returns the daily stats of a project between from and to, both inclusive.
+func (pg *postgres) GetDownloadStats(projectId string, from time.Time, to time.Time) ([]DailyDownloads, error) {
+	rows, err := pg.Db.Query(context.Background(),
+		`SELECT s.day, s.version, v.version_code, s.client, s.referrer, s.downloads
+			FROM download_stats s LEFT JOIN versions v ON v.id = s.version
+			WHERE s.project = $1 AND s.day BETWEEN $2 AND $3
+			ORDER BY s.day, s.version, s.client, s.referrer`,
+		projectId, from, to)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowToStructByName[DailyDownloads])
+}
@@ -23,6 +23,8 @@ type Project struct {
 	Author        string     `json:"author"`
 	Description   string     `json:"description"`
 	Body          string     `json:"body"`
+	BodyHTML      string     `json:"body_html"` // Body rendered by markdown.Render
+	BodyRenderer  int        `json:"-"`         // the markdown.RendererKey BodyHTML was rendered with
 	Creation      time.Time  `json:"creation"`
 	Updated       time.Time  `json:"updated"`
 	Status        string     `json:"status"`
@@ -44,4 +46,71 @@ type Version struct {
 	Supports     []string  `json:"supports"`
 	Project      string    `json:"project"`
 	RpDownload   *string   `json:"rp_download,omitempty"`
+	Changelog    string    `json:"changelog"`
+	Yanked       bool      `json:"yanked"`  // hidden from latest, still downloadable by direct link
+	Channel      string    `json:"channel"` // one of VERSION_CHANNELS
+	VersionSort  string    `json:"-"`       // sort key of VersionCode, see semver.SortKey
+
+	// Changelog rendered by markdown.Render, and the markdown.RendererKey it was rendered with
+	ChangelogHTML     string `json:"changelog_html"`
+	ChangelogRenderer int    `json:"-"`
+
+	// read from the uploaded pack.mcmeta, nil on versions uploaded before it was parsed
+	PackFormat      *int    `json:"pack_format"`
+	MinFormat       *int    `json:"min_format"`
+	MaxFormat       *int    `json:"max_format"`
+	PackDescription *string `json:"pack_description"`
+
+	Warnings []string `json:"warnings"`
+
+	// names the files were uploaded with, nil on versions uploaded before they were kept
+	FileName   *string `json:"file_name"`
+	RpFileName *string `json:"rp_file_name,omitempty"`
+
+	// digests of the stored files, nil on versions uploaded before they were recorded
+	Hashes   *FileHashes `json:"hashes"`
+	RpHashes *FileHashes `json:"rp_hashes,omitempty"`
+
+	// whether the datapack's contents are in version_namespaces and version_resources
+	ContentsIndexed bool `json:"-"`
+
+	// loaded from version_files where needed, not a column of versions
+	Files []VersionFile `json:"files,omitempty" db:"-"`
+}
+
+// FileHashes are the hex encoded digests of a stored file, keyed by HASH_ALGORITHMS.
+type FileHashes struct {
+	SHA1   string `json:"sha1"`
+	SHA256 string `json:"sha256"`
+	SHA512 string `json:"sha512"`
+}
+
+// ProjectRow is a project together with the sort key of the query that returned it.
+type ProjectRow struct {
+	Project
+	SortKey string `json:"-"`
+}
+
+func (row ProjectRow) CursorKey() (string, string) {
+	return row.SortKey, row.ID
+}
+
+// VersionRow is a version together with the sort key of the query that returned it.
+type VersionRow struct {
+	Version
+	SortKey string `json:"-"`
+}
+
+func (row VersionRow) CursorKey() (string, string) {
+	return row.SortKey, row.ID
+}
+
+// UserRow is a user together with the sort key of the query that returned it.
+type UserRow struct {
+	User
+	SortKey string `json:"-"`
+}
+
+func (row UserRow) CursorKey() (string, string) {
+	return row.SortKey, row.ID
 }
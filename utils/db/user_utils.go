@@ -3,6 +3,7 @@ package db
 import (
 	"context"
 	"github.com/HoodieRocks/dph-api-2/utils"
+	"github.com/HoodieRocks/dph-api-2/utils/paging"
 	"github.com/jackc/pgx/v5"
 	"github.com/labstack/gommon/log"
 	nanoid "github.com/matoous/go-nanoid/v2"
@@ -114,20 +115,17 @@ func (pg *postgres) UpdateUser(tx pgx.Tx, user User) error {
 	return err
 }
 
-func (pg *postgres) GetAllInRole(id string) ([]User, error) {
+// GetAllInRole pages through the users in a role, longest-serving first.
+func (pg *postgres) GetAllInRole(role string, req paging.Request) ([]UserRow, error) {
+	var qb queryBuilder
+	qb.where("role = " + qb.arg(role))
+	var page = qb.keyset("join_date", "timestamp", false, req)
 
-	var users []User
-	rows, err := pg.Db.Query(context.Background(), `SELECT * FROM users WHERE role = $1`, id)
+	rows, err := pg.Db.Query(context.Background(), `SELECT *`+sortKeyColumn("join_date")+` FROM users`+qb.whereClause()+page, qb.args...)
 
 	if err != nil {
 		return nil, err
 	}
 
-	users, err = pgx.CollectRows(rows, pgx.RowToStructByName[User])
-
-	if err != nil {
-		return nil, err
-	}
-
-	return users, nil
+	return pgx.CollectRows(rows, pgx.RowToStructByName[UserRow])
 }
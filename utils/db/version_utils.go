@@ -2,12 +2,39 @@ package db
 
 import (
 	"context"
+	"errors"
+	"slices"
 
+	derrors "github.com/HoodieRocks/dph-api-2/errors"
+	"github.com/HoodieRocks/dph-api-2/utils/markdown"
+	"github.com/HoodieRocks/dph-api-2/utils/paging"
+	"github.com/HoodieRocks/dph-api-2/utils/semver"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	nanoid "github.com/matoous/go-nanoid/v2"
 )
 
-func (pg *postgres) CreateVersion(tx pgx.Tx, projectId string, version Version) error {
+// Release channels of a version, from most to least stable.
+const (
+	ReleaseChannel = "release"
+	BetaChannel    = "beta"
+	AlphaChannel   = "alpha"
+)
+
+var VERSION_CHANNELS = []string{ReleaseChannel, BetaChannel, AlphaChannel}
+
+// ChannelsUpTo returns channel and every channel more stable than it, e.g. beta gives release and beta.
+// The bool is false if channel is unknown.
+func ChannelsUpTo(channel string) ([]string, bool) {
+	idx := slices.Index(VERSION_CHANNELS, channel)
+	if idx < 0 {
+		return nil, false
+	}
+	return VERSION_CHANNELS[:idx+1], true
+}
+
+// CreateVersion stores a new version and returns its id.
+func (pg *postgres) CreateVersion(tx pgx.Tx, projectId string, version Version) (string, error) {
 
 	id, _ := nanoid.New(12)
 
@@ -22,8 +49,22 @@ func (pg *postgres) CreateVersion(tx pgx.Tx, projectId string, version Version)
 			version_code,
 			supports,
 			project,
-			rp_download) 
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+			rp_download,
+			pack_format,
+			min_format,
+			max_format,
+			pack_description,
+			warnings,
+			hashes,
+			rp_hashes,
+			changelog,
+			channel,
+			version_sort,
+			file_name,
+			rp_file_name,
+			changelog_html,
+			changelog_renderer) 
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24)`,
 		id,
 		version.Title,
 		version.Description,
@@ -33,8 +74,29 @@ func (pg *postgres) CreateVersion(tx pgx.Tx, projectId string, version Version)
 		version.VersionCode,
 		version.Supports,
 		projectId,
-		version.RpDownload)
-	return err
+		version.RpDownload,
+		version.PackFormat,
+		version.MinFormat,
+		version.MaxFormat,
+		version.PackDescription,
+		version.Warnings,
+		version.Hashes,
+		version.RpHashes,
+		version.Changelog,
+		version.Channel,
+		semver.SortKey(version.VersionCode),
+		version.FileName,
+		version.RpFileName,
+		version.ChangelogHTML,
+		version.ChangelogRenderer)
+
+	// another upload with the same version_code got in first
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "versions_project_code_key" {
+		return "", derrors.ErrVersionCodeTaken
+	}
+
+	return id, err
 }
 
 func (pg *postgres) GetAllProjectVersions(projectId string) ([]Version, error) {
@@ -51,18 +113,232 @@ func (pg *postgres) GetAllProjectVersions(projectId string) ([]Version, error) {
 	return versions, err
 }
 
-func (pg *postgres) GetVersionByCreation(projectId string, idx int) (*Version, error) {
-	var row, err = pg.Db.Query(context.Background(), `SELECT * FROM versions WHERE project = $1 ORDER BY creation`, projectId)
+// VersionSort is a whitelisted ordering for version listings, see ProjectSort.
+type VersionSort struct {
+	Expr string
+	Type string
+}
+
+// VERSION_SORTS maps the public sort names onto their SQL expressions, both newest first.
+// version_sort is compared byte by byte, so cursor keys need the same collation.
+var VERSION_SORTS = map[string]VersionSort{
+	"newest":  {Expr: "creation", Type: "timestamp"},
+	"version": {Expr: "version_sort", Type: `text COLLATE "C"`},
+}
+
+const DefaultVersionSort = "newest"
+
+// ListProjectVersions pages through the versions of a project in the order of sort, newest first.
+// If channels is not empty, only versions in those channels are listed.
+func (pg *postgres) ListProjectVersions(projectId string, channels []string, sort VersionSort, req paging.Request) ([]VersionRow, error) {
+	var qb queryBuilder
+	qb.where("project = " + qb.arg(projectId))
+	if len(channels) > 0 {
+		qb.where("channel = ANY(" + qb.arg(channels) + ")")
+	}
+	var page = qb.keyset(sort.Expr, sort.Type, true, req)
+
+	var rows, err = pg.Db.Query(context.Background(), `SELECT *`+sortKeyColumn(sort.Expr)+` FROM versions`+qb.whereClause()+page, qb.args...)
 
 	if err != nil {
 		return nil, err
 	}
 
-	versions, err := pgx.CollectRows(row, pgx.RowToStructByName[Version])
+	return pgx.CollectRows(rows, pgx.RowToStructByName[VersionRow])
+}
+
+// GetVersionByRef looks up a version of a project by its id or its version_code. An id wins over a
+// version_code that happens to look the same.
+func (pg *postgres) GetVersionByRef(projectId string, ref string) (*Version, error) {
+	var row, err = pg.Db.Query(context.Background(),
+		`SELECT * FROM versions WHERE project = $1 AND (id = $2 OR version_code = $2)
+			ORDER BY id = $2 DESC LIMIT 1`,
+		projectId, ref)
 
-	if err != nil || int(idx) > len(versions) {
+	if err != nil {
 		return nil, err
 	}
 
-	return &versions[idx], err
+	version, err := pgx.CollectOneRow(row, pgx.RowToStructByName[Version])
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &version, nil
+}
+
+// VersionCodeExists reports whether a project already has a version with versionCode.
+func (pg *postgres) VersionCodeExists(projectId string, versionCode string) (bool, error) {
+	var exists bool
+	err := pg.Db.QueryRow(context.Background(),
+		`SELECT EXISTS (SELECT 1 FROM versions WHERE project = $1 AND version_code = $2)`,
+		projectId, versionCode).Scan(&exists)
+	return exists, err
+}
+
+// GetLatestVersion returns the highest version of a project, by semantic version of its version_code
+// and then upload time, that is in one of channels and hasn't been yanked. If gameVersion is not empty,
+// the version also has to support it.
+func (pg *postgres) GetLatestVersion(projectId string, channels []string, gameVersion string) (*Version, error) {
+	var row, err = pg.Db.Query(context.Background(),
+		`SELECT * FROM versions
+			WHERE project = $1 AND channel = ANY($2) AND NOT yanked AND ($3::text = '' OR $3 = ANY(supports))
+			ORDER BY version_sort DESC, creation DESC, id COLLATE "C" DESC LIMIT 1`,
+		projectId, channels, gameVersion)
+
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := pgx.CollectOneRow(row, pgx.RowToStructByName[Version])
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &version, nil
+}
+
+// RanksAfter reports whether version is newer than other in the order GetLatestVersion picks from. Ids are
+// compared byte by byte, which is why the queries order them with COLLATE "C".
+func (version Version) RanksAfter(other Version) bool {
+	if version.VersionSort != other.VersionSort {
+		return version.VersionSort > other.VersionSort
+	}
+	if !version.Creation.Equal(other.Creation) {
+		return version.Creation.After(other.Creation)
+	}
+	return version.ID > other.ID
+}
+
+// MaxVersionsBetween caps how many versions ListVersionsBetween returns.
+const MaxVersionsBetween = 50
+
+// ListVersionsBetween returns the versions of a project that rank after from, up to and including to,
+// highest first. Only versions in channels that haven't been yanked are listed.
+func (pg *postgres) ListVersionsBetween(projectId string, from Version, to Version, channels []string) ([]Version, error) {
+	var rows, err = pg.Db.Query(context.Background(),
+		`SELECT * FROM versions
+			WHERE project = $1 AND channel = ANY($2) AND NOT yanked
+				AND (version_sort, creation, id COLLATE "C") > ($3, $4, $5)
+				AND (version_sort, creation, id COLLATE "C") <= ($6, $7, $8)
+			ORDER BY version_sort DESC, creation DESC, id COLLATE "C" DESC LIMIT $9`,
+		projectId, channels,
+		from.VersionSort, from.Creation, from.ID,
+		to.VersionSort, to.Creation, to.ID,
+		MaxVersionsBetween)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowToStructByName[Version])
+}
+
+// BackfillVersionSort computes the sort keys of versions created before version codes were ordered.
+func (pg *postgres) BackfillVersionSort() error {
+	rows, err := pg.Db.Query(context.Background(), `SELECT id, version_code FROM versions WHERE version_sort = ''`)
+
+	if err != nil {
+		return err
+	}
+
+	batch := &pgx.Batch{}
+
+	var id, versionCode string
+	_, err = pgx.ForEachRow(rows, []any{&id, &versionCode}, func() error {
+		// codes that aren't semantic versions keep the empty key
+		if key := semver.SortKey(versionCode); key != "" {
+			batch.Queue(`UPDATE versions SET version_sort = $1 WHERE id = $2`, key, id)
+		}
+		return nil
+	})
+
+	if err != nil || batch.Len() == 0 {
+		return err
+	}
+
+	return pg.Db.SendBatch(context.Background(), batch).Close()
+}
+
+// SetChangelog sets the changelog of a version along with its rendered HTML.
+func (version *Version) SetChangelog(changelog string) {
+	version.Changelog = changelog
+	version.ChangelogHTML = markdown.Render(changelog)
+	version.ChangelogRenderer = markdown.RendererKey
+}
+
+// BackfillChangelogHTML renders the changelogs that were rendered by an older or differently configured
+// renderer, or not at all. A changelog edited while it runs already has its new rendering and is left alone.
+func (pg *postgres) BackfillChangelogHTML() error {
+	rows, err := pg.Db.Query(context.Background(), `SELECT id, changelog FROM versions WHERE changelog_renderer <> $1`, markdown.RendererKey)
+
+	if err != nil {
+		return err
+	}
+
+	batch := &pgx.Batch{}
+
+	var id, changelog string
+	_, err = pgx.ForEachRow(rows, []any{&id, &changelog}, func() error {
+		batch.Queue(`UPDATE versions SET changelog_html = $1, changelog_renderer = $2
+				WHERE id = $3 AND changelog = $4 AND changelog_renderer <> $2`,
+			markdown.Render(changelog), markdown.RendererKey, id, changelog)
+		return nil
+	})
+
+	if err != nil || batch.Len() == 0 {
+		return err
+	}
+
+	return pg.Db.SendBatch(context.Background(), batch).Close()
+}
+
+// ListVersionChangelogs returns the versions of a project that have a changelog, newest first.
+func (pg *postgres) ListVersionChangelogs(projectId string) ([]Version, error) {
+	rows, err := pg.Db.Query(context.Background(),
+		`SELECT * FROM versions WHERE project = $1 AND changelog <> '' ORDER BY creation DESC, id DESC`, projectId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowToStructByName[Version])
+}
+
+// UpdateVersion saves the fields of a version its author may edit after uploading it.
+func (pg *postgres) UpdateVersion(tx pgx.Tx, version Version) error {
+	_, err := tx.Exec(context.Background(),
+		`UPDATE versions SET
+			title = $1,
+			description = $2,
+			supports = $3,
+			changelog = $4,
+			warnings = $5,
+			channel = $6,
+			changelog_html = $7,
+			changelog_renderer = $8
+		WHERE id = $9`,
+		version.Title,
+		version.Description,
+		version.Supports,
+		version.Changelog,
+		version.Warnings,
+		version.Channel,
+		version.ChangelogHTML,
+		version.ChangelogRenderer,
+		version.ID)
+	return err
+}
+
+// SetVersionYanked hides a version from latest resolution, or brings it back.
+func (pg *postgres) SetVersionYanked(tx pgx.Tx, id string, yanked bool) error {
+	_, err := tx.Exec(context.Background(), `UPDATE versions SET yanked = $1 WHERE id = $2`, yanked, id)
+	return err
+}
+
+func (pg *postgres) DeleteVersion(tx pgx.Tx, id string) error {
+	_, err := tx.Exec(context.Background(), `DELETE FROM versions WHERE id = $1`, id)
+	return err
 }
@@ -0,0 +1,162 @@
+package files
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	derrors "github.com/HoodieRocks/dph-api-2/errors"
+)
+
+// ArchiveLimits bound what an uploaded zip may contain and expand to.
+type ArchiveLimits struct {
+	MaxSize         int64   // size of the upload itself
+	MaxUncompressed int64   // total size of every entry once extracted
+	MaxEntries      int     // files and folders
+	MaxRatio        float64 // uncompressed to compressed size, for entries over ratioCheckSize
+	MaxDepth        int     // folders deep an entry may be
+}
+
+var VersionArchiveLimits = ArchiveLimits{
+	MaxSize:         5 * 1024 * 1024,
+	MaxUncompressed: 50 * 1024 * 1024,
+	MaxEntries:      20000,
+	MaxRatio:        100,
+	MaxDepth:        24,
+}
+
+var ResourcePackArchiveLimits = ArchiveLimits{
+	MaxSize:         50 * 1024 * 1024,
+	MaxUncompressed: 250 * 1024 * 1024,
+	MaxEntries:      50000,
+	MaxRatio:        100,
+	MaxDepth:        24,
+}
+
+// tiny entries compress to almost nothing legitimately, so their ratio says nothing
+const ratioCheckSize = 64 * 1024
+
+// the general purpose flag bit the zip format sets on encrypted entries
+const zipFlagEncrypted = 0x1
+
+// InspectArchive checks the zip at filePath against limits before anything else reads it.
+// It rejects zip bombs, paths that could escape an extraction folder, symlinks and encrypted entries.
+// The declared sizes in zip headers can lie, so every entry is also decompressed and measured.
+func InspectArchive(filePath string, limits ArchiveLimits) error {
+	archive, err := zip.OpenReader(filePath)
+	if err != nil {
+		return derrors.ErrArchiveInvalid
+	}
+	defer archive.Close()
+
+	if len(archive.File) > limits.MaxEntries {
+		return derrors.ErrArchiveTooManyEntries
+	}
+
+	var names = make(map[string]bool, len(archive.File))
+	var declaredTotal uint64
+
+	for _, file := range archive.File {
+		if err = checkEntryHeader(file, limits); err != nil {
+			return err
+		}
+
+		if names[file.Name] {
+			return fmt.Errorf("%w: %s appears twice", derrors.ErrArchiveUnsafePath, file.Name)
+		}
+		names[file.Name] = true
+
+		declaredTotal += file.UncompressedSize64
+		if declaredTotal > uint64(limits.MaxUncompressed) {
+			return derrors.ErrArchiveTooLarge
+		}
+	}
+
+	// the headers add up, now make sure the data agrees with them
+	var actualTotal int64
+
+	for _, file := range archive.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+
+		size, err := measureEntry(file)
+		if err != nil {
+			return err
+		}
+
+		actualTotal += size
+		if actualTotal > limits.MaxUncompressed {
+			return derrors.ErrArchiveTooLarge
+		}
+	}
+
+	return nil
+}
+
+// checkEntryHeader checks everything about an entry that can be read without decompressing it.
+func checkEntryHeader(file *zip.File, limits ArchiveLimits) error {
+	if !safeEntryPath(file.Name) {
+		return fmt.Errorf("%w: %q", derrors.ErrArchiveUnsafePath, file.Name)
+	}
+
+	if file.Mode()&os.ModeSymlink != 0 {
+		return fmt.Errorf("%w: %s", derrors.ErrArchiveSymlink, file.Name)
+	}
+
+	if file.Flags&zipFlagEncrypted != 0 {
+		return fmt.Errorf("%w: %s", derrors.ErrArchiveEncrypted, file.Name)
+	}
+
+	if strings.Count(strings.TrimSuffix(file.Name, "/"), "/")+1 > limits.MaxDepth {
+		return fmt.Errorf("%w: %s", derrors.ErrArchiveTooDeep, file.Name)
+	}
+
+	if file.UncompressedSize64 > ratioCheckSize {
+		if file.CompressedSize64 == 0 || float64(file.UncompressedSize64)/float64(file.CompressedSize64) > limits.MaxRatio {
+			return fmt.Errorf("%w: %s", derrors.ErrArchiveCompressionRatio, file.Name)
+		}
+	}
+
+	return nil
+}
+
+// safeEntryPath rejects entry names that would land outside the folder they are extracted to,
+// or that mean different things on different operating systems.
+func safeEntryPath(name string) bool {
+	if name == "" || strings.ContainsAny(name, "\\\x00:") || strings.HasPrefix(name, "/") {
+		return false
+	}
+
+	for _, segment := range strings.Split(strings.TrimSuffix(name, "/"), "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return false
+		}
+	}
+
+	return path.Clean(name) == strings.TrimSuffix(name, "/")
+}
+
+// measureEntry decompresses an entry and returns its real size, which may not exceed its declared size.
+func measureEntry(file *zip.File) (int64, error) {
+	reader, err := file.Open()
+	if err != nil {
+		return 0, derrors.ErrArchiveInvalid
+	}
+	defer reader.Close()
+
+	size, err := io.Copy(io.Discard, io.LimitReader(reader, int64(file.UncompressedSize64)+1))
+	if err != nil {
+		// archive/zip reports checksum and length mismatches here
+		return 0, fmt.Errorf("%w: %s", derrors.ErrArchiveInvalid, file.Name)
+	}
+
+	if uint64(size) > file.UncompressedSize64 {
+		return 0, fmt.Errorf("%w: %s", derrors.ErrArchiveSizeMismatch, file.Name)
+	}
+
+	return size, nil
+}
@@ -0,0 +1,151 @@
+package files
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	derrors "github.com/HoodieRocks/dph-api-2/errors"
+)
+
+var testLimits = ArchiveLimits{
+	MaxSize:         1024 * 1024,
+	MaxUncompressed: 256 * 1024,
+	MaxEntries:      8,
+	MaxRatio:        100,
+	MaxDepth:        4,
+}
+
+// testEntry is a file of an archive built by writeTestArchive.
+type testEntry struct {
+	name string
+	data []byte
+	mode os.FileMode
+	flag uint16
+}
+
+// writeTestArchive builds a zip with entries in memory and writes it to a temporary file.
+func writeTestArchive(t *testing.T, entries ...testEntry) string {
+	t.Helper()
+
+	var buffer bytes.Buffer
+	var archive = zip.NewWriter(&buffer)
+
+	for _, entry := range entries {
+		var header = &zip.FileHeader{Name: entry.name, Method: zip.Deflate, Flags: entry.flag}
+		if entry.mode != 0 {
+			header.SetMode(entry.mode)
+		}
+
+		writer, err := archive.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = writer.Write(entry.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var path = filepath.Join(t.TempDir(), "test.zip")
+	if err := os.WriteFile(path, buffer.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// textEntry is a plain text file of an archive.
+func textEntry(name string, data string) testEntry {
+	return testEntry{name: name, data: []byte(data)}
+}
+
+func TestInspectArchive(t *testing.T) {
+	var many []testEntry
+	for i := 0; i <= testLimits.MaxEntries; i++ {
+		many = append(many, textEntry("data/x/function/f"+strings.Repeat("a", i)+".mcfunction", "say hi"))
+	}
+
+	// entries under ratioCheckSize, so only their total is too much
+	var large []testEntry
+	for i := 0; i < 5; i++ {
+		large = append(large, testEntry{name: "data/x/" + strings.Repeat("b", i+1) + ".json", data: make([]byte, 60*1024)})
+	}
+
+	var tests = []struct {
+		name    string
+		entries []testEntry
+		want    error
+	}{
+		{"valid pack", []testEntry{textEntry("pack.mcmeta", `{"pack":{}}`), textEntry("data/x/function/a.mcfunction", "say hi")}, nil},
+		{"folder entries", []testEntry{textEntry("data/", ""), textEntry("data/x/", "")}, nil},
+		{"too many entries", many, derrors.ErrArchiveTooManyEntries},
+		{"too deep", []testEntry{textEntry("a/b/c/d/e.json", "{}")}, derrors.ErrArchiveTooDeep},
+		{"compression ratio", []testEntry{{name: "bomb.json", data: make([]byte, 256*1024)}}, derrors.ErrArchiveCompressionRatio},
+		{"too large", large, derrors.ErrArchiveTooLarge},
+		{"parent folder", []testEntry{textEntry("../evil.json", "{}")}, derrors.ErrArchiveUnsafePath},
+		{"parent folder inside", []testEntry{textEntry("data/../../evil.json", "{}")}, derrors.ErrArchiveUnsafePath},
+		{"absolute path", []testEntry{textEntry("/etc/passwd", "x")}, derrors.ErrArchiveUnsafePath},
+		{"backslash path", []testEntry{textEntry("data\\..\\evil.json", "{}")}, derrors.ErrArchiveUnsafePath},
+		{"drive letter", []testEntry{textEntry("C:/evil.json", "{}")}, derrors.ErrArchiveUnsafePath},
+		{"empty segment", []testEntry{textEntry("data//evil.json", "{}")}, derrors.ErrArchiveUnsafePath},
+		{"duplicate name", []testEntry{textEntry("a.json", "{}"), textEntry("a.json", "{}")}, derrors.ErrArchiveUnsafePath},
+		{"symlink", []testEntry{{name: "link", data: []byte("/etc/passwd"), mode: os.ModeSymlink | 0777}}, derrors.ErrArchiveSymlink},
+		{"encrypted", []testEntry{{name: "secret.json", data: []byte("{}"), flag: zipFlagEncrypted}}, derrors.ErrArchiveEncrypted},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var err = InspectArchive(writeTestArchive(t, test.entries...), testLimits)
+
+			if test.want == nil && err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if test.want != nil && !errors.Is(err, test.want) {
+				t.Fatalf("expected %v, got %v", test.want, err)
+			}
+		})
+	}
+}
+
+func TestInspectArchiveNotZip(t *testing.T) {
+	var path = filepath.Join(t.TempDir(), "test.zip")
+	if err := os.WriteFile(path, []byte("not a zip"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := InspectArchive(path, testLimits); !errors.Is(err, derrors.ErrArchiveInvalid) {
+		t.Fatalf("expected %v, got %v", derrors.ErrArchiveInvalid, err)
+	}
+}
+
+func TestSafeEntryPath(t *testing.T) {
+	var tests = map[string]bool{
+		"pack.mcmeta":                  true,
+		"data/x/function/a.mcfunction": true,
+		"data/":                        true,
+		"":                             false,
+		"..":                           false,
+		"../a":                         false,
+		"a/../../b":                    false,
+		"a/./b":                        false,
+		"/a":                           false,
+		"a\\b":                         false,
+		"c:/a":                         false,
+		"a\x00b":                       false,
+		"a//b":                         false,
+	}
+
+	for name, want := range tests {
+		if got := safeEntryPath(name); got != want {
+			t.Errorf("safeEntryPath(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package files
+
+import (
+	"context"
+
+	"github.com/HoodieRocks/dph-api-2/utils/datapack"
+	"github.com/HoodieRocks/dph-api-2/utils/db"
+	"github.com/labstack/gommon/log"
+)
+
+// BackfillVersionContents indexes the datapacks of versions uploaded before their contents were indexed.
+// Versions whose files are gone or whose contents fail to store are logged and skipped.
+func BackfillVersionContents() error {
+	var conn = db.EstablishConnection()
+
+	versions, err := conn.ListVersionsWithoutContents()
+
+	if err != nil {
+		return err
+	}
+
+	for _, version := range versions {
+		contents, err := datapack.IndexFile(LocalPath(version.DownloadLink))
+		if err != nil {
+			log.Warnf("failed to index version %s: %v\n", version.ID, err)
+			continue
+		}
+
+		tx, err := conn.Db.Begin(context.Background())
+		if err != nil {
+			return err
+		}
+
+		// one version failing to store, e.g. because it was deleted meanwhile, shouldn't hold up the others
+		if err = conn.CreateVersionContents(tx, version.ID, contents); err != nil {
+			tx.Rollback(context.Background())
+			log.Warnf("failed to store the contents of version %s: %v\n", version.ID, err)
+			continue
+		}
+
+		if err = tx.Commit(context.Background()); err != nil {
+			log.Warnf("failed to store the contents of version %s: %v\n", version.ID, err)
+			continue
+		}
+	}
+
+	return nil
+}
@@ -1,111 +1,179 @@
 package files
 
 import (
-	"archive/zip"
 	"io"
 	"mime/multipart"
 	"os"
+	"path/filepath"
 	"slices"
 	"strings"
+	"time"
 
 	derrors "github.com/HoodieRocks/dph-api-2/errors"
+	"github.com/HoodieRocks/dph-api-2/utils/datapack"
 	"github.com/HoodieRocks/dph-api-2/utils/db"
 
 	"github.com/h2non/bimg"
 	"github.com/mrz1836/go-sanitize"
 )
 
-func UploadFile(file *multipart.FileHeader, maxSize int64, fileTypes []string, filename string, folder string) (*os.File, error) {
+// StoredFile is an upload that has been written under ./files.
+type StoredFile struct {
+	Link     string // download link, see LocalPath
+	FileName string // name it was uploaded with, for Content-Disposition
+	Size     int64
+	Hashes   db.FileHashes
+}
+
+// VersionFile describes the stored file as one of the files of a version.
+func (stored StoredFile) VersionFile(role string) db.VersionFile {
+	return db.VersionFile{
+		Role:         role,
+		FileName:     stored.FileName,
+		DownloadLink: stored.Link,
+		Size:         stored.Size,
+		Hashes:       stored.Hashes,
+		Creation:     time.Now(),
+	}
+}
+
+func UploadFile(file *multipart.FileHeader, maxSize int64, fileTypes []string, filename string, folder string) (*os.File, db.FileHashes, error) {
 	// Open the file
 	src, err := file.Open()
 	if err != nil {
-		return nil, err
+		return nil, db.FileHashes{}, err
 	}
 
 	defer src.Close()
 
 	if file.Size > maxSize {
-		return nil, derrors.ErrFileTooLarge
+		return nil, db.FileHashes{}, derrors.ErrFileTooLarge
 	}
 
 	var splitFileName = strings.Split(file.Filename, ".")
 	var fileExt = strings.ToLower(splitFileName[len(splitFileName)-1])
 
 	if !slices.Contains(fileTypes, fileExt) {
-		return nil, derrors.ErrFileBadExtension
+		return nil, db.FileHashes{}, derrors.ErrFileBadExtension
 	}
 
-	var safeFilename = sanitize.PathName(file.Filename)
+	var safeFilename = strings.TrimSuffix(sanitize.PathName(file.Filename), fileExt)
 
 	// Destination
-	dst, err := os.Create("./files/" + folder + "/" + strings.TrimSuffix(safeFilename, "zip") + "." + fileExt)
+	if err = os.MkdirAll("./files/"+folder, 0755); err != nil {
+		return nil, db.FileHashes{}, err
+	}
+	// a random suffix keeps uploads with the same name from overwriting each other,
+	// which also means removing a rejected upload can never take an older file with it
+	dst, err := os.CreateTemp("./files/"+folder, safeFilename+"-*."+fileExt)
 	if err != nil {
-		return nil, err
+		return nil, db.FileHashes{}, err
 	}
 	defer dst.Close()
 
-	// Copy
-	if _, err = io.Copy(dst, src); err != nil {
-		return nil, err
+	// Copy, never trusting the declared size, and hash the file on the way
+	var digests = newHasher()
+	written, err := io.Copy(io.MultiWriter(dst, digests), io.LimitReader(src, maxSize+1))
+	if err == nil && written > maxSize {
+		err = derrors.ErrFileTooLarge
+	}
+	if err != nil {
+		os.Remove(dst.Name())
+		return nil, db.FileHashes{}, err
 	}
 
-	return dst, nil
+	return dst, digests.Sum(), nil
 }
 
-func UploadZipFile(file *multipart.FileHeader, maxSize int64, folder string) (string, error) {
-
-	var safeFilename = sanitize.PathName(file.Filename)
-	dst, err := UploadFile(file, maxSize, []string{"zip"}, file.Filename, folder)
+// UploadZipFile stores a zip and inspects it against limits, removing it again if it is rejected.
+func UploadZipFile(file *multipart.FileHeader, limits ArchiveLimits, folder string) (StoredFile, error) {
+	dst, hashes, err := UploadFile(file, limits.MaxSize, []string{"zip"}, file.Filename, folder)
 
 	if err != nil {
-		return "", err
+		return StoredFile{}, err
+	}
+
+	if err = InspectArchive(dst.Name(), limits); err != nil {
+		os.Remove(dst.Name())
+		return StoredFile{}, err
 	}
 
-	newHandle, _ := os.Open(dst.Name())
-	fs, err := newHandle.Stat()
-	defer newHandle.Close()
+	info, err := dst.Stat()
 
 	if err != nil {
-		return "", err
+		os.Remove(dst.Name())
+		return StoredFile{}, err
 	}
 
-	header, err := zip.FileInfoHeader(fs)
+	return StoredFile{
+		Link:     "/files/" + folder + "/" + filepath.Base(dst.Name()),
+		FileName: DownloadName(file.Filename),
+		Size:     info.Size(),
+		Hashes:   hashes,
+	}, nil
+}
 
-	if err != nil {
-		return "", err
+// DownloadName cleans up the name a file was uploaded with for sending it back in Content-Disposition.
+func DownloadName(filename string) string {
+	var name = strings.TrimSpace(filepath.Base(strings.ReplaceAll(filename, "\\", "/")))
+
+	if name == "" || name == "." || name == "/" {
+		return "download.zip"
 	}
 
-	if header.UncompressedSize64 > uint64(maxSize*2) {
-		err = os.Remove("./files/" + folder + "/" + safeFilename)
+	return name
+}
+
+// LocalPath maps a /files download link back to where the file is stored on disk.
+func LocalPath(link string) string {
+	return "." + link
+}
+
+// RemoveUpload deletes a stored file by its download link, e.g. when the upload is rejected after the fact.
+func RemoveUpload(link string) {
+	os.Remove(LocalPath(link))
+}
+
+// UploadVersionFile stores a datapack zip, reads the pack.mcmeta at its root and validates its structure.
+// Archives without a valid pack.mcmeta, or that fail validation, are removed again and rejected.
+func UploadVersionFile(file *multipart.FileHeader, project db.Project) (StoredFile, datapack.Inspection, error) {
+	stored, err := UploadZipFile(file, VersionArchiveLimits, "versions/"+project.Slug)
+
+	if err != nil {
+		return StoredFile{}, datapack.Inspection{}, err
+	}
 
-		if err != nil {
-			return "", err
-		}
+	inspection, err := datapack.InspectFile(LocalPath(stored.Link))
 
-		return "", derrors.ErrFileTooLarge
+	if err != nil {
+		RemoveUpload(stored.Link)
+		return StoredFile{}, inspection, err
 	}
 
-	return "/files/" + folder + "/" + file.Filename, nil
+	return stored, inspection, nil
 }
 
-func UploadVersionFile(file *multipart.FileHeader, project db.Project) (string, error) {
-	return UploadZipFile(file, 5*1024*1024, "versions/"+project.Slug)
+func UploadResourcePackFile(file *multipart.FileHeader, project db.Project) (StoredFile, error) {
+	return UploadZipFile(file, ResourcePackArchiveLimits, "resources/"+project.Slug)
 }
 
-func UploadResourcePackFile(file *multipart.FileHeader, project db.Project) (string, error) {
-	return UploadZipFile(file, 50*1024*1024, "resources/"+project.Slug)
+// UploadAddonFile stores an optional add-on of a version. Add-ons can be datapacks or resource packs,
+// so they are only checked for being safe archives, not for their structure.
+func UploadAddonFile(file *multipart.FileHeader, project db.Project) (StoredFile, error) {
+	return UploadZipFile(file, ResourcePackArchiveLimits, "addons/"+project.Slug)
 }
 
 func UploadIconFile(file *multipart.FileHeader, project db.Project) (string, error) {
-	_, err := UploadFile(file, 2*1024*1024, []string{"png", "jpg"}, project.Slug+"png", "icons")
+	dst, _, err := UploadFile(file, 2*1024*1024, []string{"png", "jpg"}, project.Slug+"png", "icons")
 
 	if err != nil {
 		return "", err
 	}
 
-	//TODO security hotspot path traversal attack possible?
-	//TODO dot missing?
-	buffer, err := bimg.Read("./files/icons/" + project.Slug + "png")
+	// the original is only needed until it has been converted
+	defer os.Remove(dst.Name())
+
+	buffer, err := bimg.Read(dst.Name())
 
 	if err != nil {
 		return "", err
@@ -124,7 +192,5 @@ func UploadIconFile(file *multipart.FileHeader, project db.Project) (string, err
 		return "", err
 	}
 
-	go os.Remove("./files/icons/" + project.Slug + "png")
-
 	return "/files/icons/" + project.Slug + ".webp", nil
 }
@@ -0,0 +1,134 @@
+package files
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"hash"
+	"io"
+	"os"
+	"time"
+
+	"github.com/HoodieRocks/dph-api-2/utils/db"
+	"github.com/labstack/gommon/log"
+)
+
+// hasher computes every digest in db.HASH_ALGORITHMS in one pass over a file.
+type hasher struct {
+	sha1   hash.Hash
+	sha256 hash.Hash
+	sha512 hash.Hash
+}
+
+func newHasher() *hasher {
+	return &hasher{sha1: sha1.New(), sha256: sha256.New(), sha512: sha512.New()}
+}
+
+func (h *hasher) Write(p []byte) (int, error) {
+	h.sha1.Write(p)
+	h.sha256.Write(p)
+	h.sha512.Write(p)
+	return len(p), nil
+}
+
+func (h *hasher) Sum() db.FileHashes {
+	return db.FileHashes{
+		SHA1:   hex.EncodeToString(h.sha1.Sum(nil)),
+		SHA256: hex.EncodeToString(h.sha256.Sum(nil)),
+		SHA512: hex.EncodeToString(h.sha512.Sum(nil)),
+	}
+}
+
+// HashStoredFile computes the digests of a file that is already stored, by its download link.
+func HashStoredFile(link string) (db.FileHashes, error) {
+	file, err := os.Open(LocalPath(link))
+	if err != nil {
+		return db.FileHashes{}, err
+	}
+	defer file.Close()
+
+	var h = newHasher()
+	if _, err = io.Copy(h, file); err != nil {
+		return db.FileHashes{}, err
+	}
+
+	return h.Sum(), nil
+}
+
+// storedVersionFile describes a file stored before version_files existed, hashing it if its digests weren't
+// recorded at upload time.
+func storedVersionFile(role string, link string, fileName *string, hashes *db.FileHashes, creation time.Time) (db.VersionFile, error) {
+	info, err := os.Stat(LocalPath(link))
+	if err != nil {
+		return db.VersionFile{}, err
+	}
+
+	var file = db.VersionFile{
+		Role:         role,
+		FileName:     DownloadName(link),
+		DownloadLink: link,
+		Size:         info.Size(),
+		Creation:     creation,
+	}
+
+	if fileName != nil {
+		file.FileName = *fileName
+	}
+
+	if hashes != nil {
+		file.Hashes = *hashes
+	} else if file.Hashes, err = HashStoredFile(link); err != nil {
+		return db.VersionFile{}, err
+	}
+
+	return file, nil
+}
+
+// BackfillVersionFiles records the files of versions uploaded before they were kept in version_files.
+// Versions whose files are gone are logged and skipped.
+func BackfillVersionFiles() error {
+	var conn = db.EstablishConnection()
+
+	versions, err := conn.ListVersionsWithoutFiles()
+
+	if err != nil {
+		return err
+	}
+
+	for _, version := range versions {
+		primary, err := storedVersionFile(db.PrimaryFile, version.DownloadLink, version.FileName, version.Hashes, version.Creation)
+		if err != nil {
+			log.Warnf("failed to read file of version %s: %v\n", version.ID, err)
+			continue
+		}
+
+		var versionFiles = []db.VersionFile{primary}
+
+		if version.RpDownload != nil {
+			rp, err := storedVersionFile(db.ResourcePackFile, *version.RpDownload, version.RpFileName, version.RpHashes, version.Creation)
+			if err != nil {
+				log.Warnf("failed to read resource pack of version %s: %v\n", version.ID, err)
+				continue
+			}
+			versionFiles = append(versionFiles, rp)
+		}
+
+		tx, err := conn.Db.Begin(context.Background())
+		if err != nil {
+			return err
+		}
+
+		if err = conn.CreateVersionFiles(tx, version.ID, versionFiles); err != nil {
+			tx.Rollback(context.Background())
+			return err
+		}
+
+		if err = tx.Commit(context.Background()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
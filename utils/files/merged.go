@@ -0,0 +1,157 @@
+package files
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HoodieRocks/dph-api-2/utils/datapack"
+)
+
+// MergedPackFolder is the folder under ./files merged datapacks are cached in.
+const MergedPackFolder = "merged"
+
+// CacheLimits bound a folder of cached files.
+type CacheLimits struct {
+	MaxSize int64         // total size of the files, the least recently used go first past it
+	MaxAge  time.Duration // files unused for longer are removed
+}
+
+// Merged packs can be made by anyone, so their cache is kept in bounds.
+var MergedPackCacheLimits = CacheLimits{
+	MaxSize: 2 * 1024 * 1024 * 1024,
+	MaxAge:  7 * 24 * time.Hour,
+}
+
+// pruning keeps two merges from pruning the cache at the same time
+var pruning sync.Mutex
+
+// MergedPackLink returns the download link of the datapack merged from versions with description. Versions
+// are in load order, which decides how tags with replace are merged, so the same versions in another order
+// are another pack. datapack.MergeFormat is part of the link, so packs merged by an older Merge aren't reused.
+func MergedPackLink(versionIds []string, description string) string {
+	var key = strconv.Itoa(datapack.MergeFormat) + "\n" + description + "\n" + strings.Join(versionIds, "\n")
+	var sum = sha256.Sum256([]byte(key))
+	return "/files/" + MergedPackFolder + "/" + hex.EncodeToString(sum[:]) + ".zip"
+}
+
+// UseMergedPack reports whether a merged pack is cached at link, and marks it as used so it is kept.
+func UseMergedPack(link string) bool {
+	var now = time.Now()
+	return os.Chtimes(LocalPath(link), now, now) == nil
+}
+
+// StoreMergedPack merges packs with datapack.Merge and stores the result at link. The pack is written to a
+// temporary file first, so a merge that fails half way never ends up in the cache.
+func StoreMergedPack(link string, packs []datapack.Pack, description string) error {
+	var path = LocalPath(link)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "merge-*.tmp")
+	if err != nil {
+		return err
+	}
+
+	err = datapack.Merge(packs, description, tmp)
+
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return nil
+}
+
+// PruneMergedPacks removes the cached packs that are over MergedPackCacheLimits, except the one at keep.
+func PruneMergedPacks(keep string) error {
+	pruning.Lock()
+	defer pruning.Unlock()
+
+	return pruneCache(LocalPath("/files/"+MergedPackFolder), LocalPath(keep), MergedPackCacheLimits)
+}
+
+// pruneCache removes the .zip files in folder unused for limits.MaxAge, then the least recently used ones
+// until the folder fits in limits.MaxSize. The file at keep is never removed. Temporary files of merges are
+// only removed once they are too old to belong to a running one.
+func pruneCache(folder string, keep string, limits CacheLimits) error {
+	entries, err := os.ReadDir(folder)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var cached []os.FileInfo
+	var total int64
+
+	for _, entry := range entries {
+		info, err := entry.Info()
+
+		// removed by a merge that just failed
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		var path = filepath.Join(folder, info.Name())
+		var expired = time.Since(info.ModTime()) > limits.MaxAge
+
+		if filepath.Ext(path) != ".zip" || path == filepath.Clean(keep) {
+			if filepath.Ext(path) == ".tmp" && expired {
+				os.Remove(path)
+			} else {
+				total += info.Size()
+			}
+			continue
+		}
+
+		if expired {
+			if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+
+		cached = append(cached, info)
+		total += info.Size()
+	}
+
+	// least recently used first
+	slices.SortFunc(cached, func(a, b os.FileInfo) int {
+		return a.ModTime().Compare(b.ModTime())
+	})
+
+	for _, info := range cached {
+		if total <= limits.MaxSize {
+			break
+		}
+
+		if err = os.Remove(filepath.Join(folder, info.Name())); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		total -= info.Size()
+	}
+
+	return nil
+}
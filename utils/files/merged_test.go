@@ -0,0 +1,79 @@
+package files
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCachedFile writes a file of size bytes to folder, last used age ago.
+func writeCachedFile(t *testing.T, folder string, name string, size int, age time.Duration) string {
+	t.Helper()
+
+	var path = filepath.Join(folder, name)
+	if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var used = time.Now().Add(-age)
+	if err := os.Chtimes(path, used, used); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestPruneCache(t *testing.T) {
+	var folder = t.TempDir()
+	var limits = CacheLimits{MaxSize: 300, MaxAge: time.Hour}
+
+	var expired = writeCachedFile(t, folder, "expired.zip", 10, 2*time.Hour)
+	var oldest = writeCachedFile(t, folder, "oldest.zip", 100, 30*time.Minute)
+	var older = writeCachedFile(t, folder, "older.zip", 100, 20*time.Minute)
+	var recent = writeCachedFile(t, folder, "recent.zip", 100, 10*time.Minute)
+	var kept = writeCachedFile(t, folder, "kept.zip", 100, 40*time.Minute)
+	var running = writeCachedFile(t, folder, "merge-1.tmp", 10, time.Minute)
+	var abandoned = writeCachedFile(t, folder, "merge-2.tmp", 10, 2*time.Hour)
+
+	if err := pruneCache(folder, kept, limits); err != nil {
+		t.Fatal(err)
+	}
+
+	// 410 bytes are left after expired.zip and merge-2.tmp, the least recently used go until it fits
+	var want = map[string]bool{
+		expired:   false,
+		oldest:    false,
+		older:     false,
+		recent:    true,
+		kept:      true,
+		running:   true,
+		abandoned: false,
+	}
+
+	for path, exists := range want {
+		if _, err := os.Stat(path); (err == nil) != exists {
+			t.Errorf("%s: expected exists = %v, got error %v", filepath.Base(path), exists, err)
+		}
+	}
+}
+
+func TestPruneCacheMissingFolder(t *testing.T) {
+	if err := pruneCache(filepath.Join(t.TempDir(), "missing"), "", MergedPackCacheLimits); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+}
+
+func TestMergedPackLink(t *testing.T) {
+	var link = MergedPackLink([]string{"a", "b"}, "Merged from A 1.0, B 2.0")
+
+	if link == MergedPackLink([]string{"b", "a"}, "Merged from A 1.0, B 2.0") {
+		t.Error("expected another load order to be another pack")
+	}
+	if link == MergedPackLink([]string{"a", "b"}, "Merged from A 1.1, B 2.0") {
+		t.Error("expected another description to be another pack")
+	}
+	if link != MergedPackLink([]string{"a", "b"}, "Merged from A 1.0, B 2.0") {
+		t.Error("expected the same packs to be the same link")
+	}
+}
@@ -0,0 +1,39 @@
+package markdown
+
+import (
+	"bytes"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+)
+
+// HeadingIDPrefix starts every heading id, so ids authors pick can't clobber ids or global
+// variables of the page the HTML is shown on, e.g. a heading called "Body" or "forms".
+const HeadingIDPrefix = "user-content-"
+
+// anchorPrefixer prefixes the generated heading ids, and the links within the document that point at them.
+type anchorPrefixer struct{}
+
+func (anchorPrefixer) Transform(document *ast.Document, reader text.Reader, pc parser.Context) {
+	ast.Walk(document, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+
+		switch node := node.(type) {
+		case *ast.Heading:
+			if id, ok := node.AttributeString("id"); ok {
+				if value, ok := id.([]byte); ok {
+					node.SetAttributeString("id", append([]byte(HeadingIDPrefix), value...))
+				}
+			}
+		case *ast.Link:
+			if bytes.HasPrefix(node.Destination, []byte("#")) && len(node.Destination) > 1 {
+				node.Destination = append([]byte("#"+HeadingIDPrefix), node.Destination[1:]...)
+			}
+		}
+
+		return ast.WalkContinue, nil
+	})
+}
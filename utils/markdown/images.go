@@ -0,0 +1,116 @@
+package markdown
+
+import (
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+)
+
+// imagePolicy decides where images from other sites are loaded from, so players' browsers don't hit
+// arbitrary hosts. It is read from the environment:
+//
+//	IMAGE_HOSTS      comma separated hosts images are loaded from directly, subdomains included
+//	IMAGE_PROXY_URL  prefix the escaped URL of any other image is appended to, e.g. https://proxy.example.com/?url=
+//
+// Images on other hosts are turned into links when there is no proxy. With neither set, only images on this
+// site are shown inline.
+type imagePolicy struct {
+	hosts []string
+	proxy string
+}
+
+var images = loadImagePolicy()
+
+func loadImagePolicy() imagePolicy {
+	var policy = imagePolicy{proxy: strings.TrimSpace(os.Getenv("IMAGE_PROXY_URL"))}
+
+	for _, host := range strings.Split(os.Getenv("IMAGE_HOSTS"), ",") {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			policy.hosts = append(policy.hosts, host)
+		}
+	}
+
+	return policy
+}
+
+func (policy imagePolicy) fingerprint() string {
+	return policy.proxy + "\x00" + strings.Join(policy.hosts, ",")
+}
+
+func (policy imagePolicy) allows(host string) bool {
+	host = strings.ToLower(host)
+
+	for _, allowed := range policy.hosts {
+		if host == allowed || strings.HasSuffix(host, "."+allowed) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// rewrite returns the URL an image should be loaded from, or false if it may not be shown inline.
+// Relative URLs point at this site and are always fine.
+func (policy imagePolicy) rewrite(src string) (string, bool) {
+	parsed, err := url.Parse(src)
+
+	if err != nil {
+		return "", false
+	}
+
+	if parsed.Scheme == "" && parsed.Host == "" {
+		return src, true
+	}
+
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return "", false
+	}
+
+	if policy.allows(parsed.Hostname()) {
+		return src, true
+	}
+
+	if policy.proxy != "" {
+		return policy.proxy + url.QueryEscape(src), true
+	}
+
+	return "", false
+}
+
+// imageRewriter applies the image policy to every image in a document.
+type imageRewriter struct{}
+
+func (imageRewriter) Transform(document *ast.Document, reader text.Reader, pc parser.Context) {
+	var found []*ast.Image
+
+	ast.Walk(document, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if image, ok := node.(*ast.Image); ok && entering {
+			found = append(found, image)
+		}
+		return ast.WalkContinue, nil
+	})
+
+	for _, image := range found {
+		if src, ok := images.rewrite(string(image.Destination)); ok {
+			image.Destination = []byte(src)
+			continue
+		}
+
+		// the image can't be shown inline, link to it with its alt text instead
+		var link = ast.NewLink()
+		link.Destination = image.Destination
+		link.Title = image.Title
+
+		for child := image.FirstChild(); child != nil; {
+			var next = child.NextSibling()
+			link.AppendChild(link, child)
+			child = next
+		}
+
+		image.Parent().ReplaceChild(image.Parent(), image, link)
+	}
+}
@@ -0,0 +1,97 @@
+// Package markdown renders the Markdown authors write to HTML that clients can show as is. Raw HTML in
+// the source is dropped, and the output is sanitized against an allowlist of tags and attributes, so
+// there are no scripts, iframes, event handlers or javascript: links whatever the input.
+package markdown
+
+import (
+	"bytes"
+	"hash/fnv"
+	"html"
+	"regexp"
+	"strconv"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/util"
+)
+
+// rendererVersion is bumped whenever the output of Render changes for the same input.
+const rendererVersion = 3
+
+// RendererKey identifies the renderer along with its image configuration. It is stored next to cached HTML,
+// which is rendered again when the key changes, e.g. after an upgrade or when the image hosts change.
+var RendererKey = rendererKey()
+
+func rendererKey() int {
+	var h = fnv.New32a()
+	h.Write([]byte(strconv.Itoa(rendererVersion) + "\x00" + images.fingerprint()))
+	return int(int32(h.Sum32()))
+}
+
+var renderer = goldmark.New(
+	goldmark.WithExtensions(
+		extension.Strikethrough,
+		extension.Linkify,
+		extension.TaskList,
+		extension.NewTable(extension.WithTableCellAlignMethod(extension.TableCellAlignAttribute)),
+	),
+	goldmark.WithParserOptions(
+		parser.WithAutoHeadingID(),
+		parser.WithASTTransformers(util.Prioritized(imageRewriter{}, 100), util.Prioritized(anchorPrefixer{}, 200)),
+	),
+)
+
+var policy = newPolicy()
+
+var headingID = regexp.MustCompile(`^` + HeadingIDPrefix + `[\p{L}\p{N}_-]+$`)
+
+func newPolicy() *bluemonday.Policy {
+	var policy = bluemonday.NewPolicy()
+
+	policy.AllowElements(
+		"p", "br", "hr", "blockquote", "pre", "code",
+		"h1", "h2", "h3", "h4", "h5", "h6",
+		"em", "strong", "del", "ul", "ol", "li",
+		"a", "img",
+		"table", "thead", "tbody", "tr", "th", "td",
+	)
+
+	policy.AllowAttrs("href", "title").OnElements("a")
+	policy.AllowAttrs("src", "alt", "title").OnElements("img")
+	policy.AllowAttrs("start").Matching(bluemonday.Integer).OnElements("ol")
+	policy.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[\w+#-]+$`)).OnElements("code")
+	policy.AllowAttrs("align").Matching(regexp.MustCompile(`^(left|center|right)$`)).OnElements("th", "td")
+
+	// heading anchors, so sections can be linked to
+	policy.AllowAttrs("id").Matching(headingID).OnElements("h1", "h2", "h3", "h4", "h5", "h6")
+
+	// task list items
+	policy.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	policy.AllowAttrs("checked", "disabled").Matching(regexp.MustCompile(`^$`)).OnElements("input")
+
+	policy.AllowURLSchemes("http", "https", "mailto")
+	policy.AllowRelativeURLs(true)
+	policy.RequireParseableURLs(true)
+	policy.RequireNoFollowOnLinks(true)
+	policy.AddTargetBlankToFullyQualifiedLinks(true)
+
+	return policy
+}
+
+// Render converts Markdown to sanitized HTML.
+func Render(source string) string {
+	if source == "" {
+		return ""
+	}
+
+	var buffer bytes.Buffer
+
+	// rendering to memory doesn't fail, but never hand out unsanitized text if it does
+	if err := renderer.Convert([]byte(source), &buffer); err != nil {
+		return "<p>" + html.EscapeString(source) + "</p>"
+	}
+
+	return string(policy.SanitizeBytes(buffer.Bytes()))
+}
@@ -0,0 +1,113 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderSanitizes(t *testing.T) {
+	var tests = []struct {
+		name   string
+		source string
+	}{
+		{"script tag", "<script>alert(1)</script>"},
+		{"inline script", "hello <script>alert(1)</script> world"},
+		{"iframe", `<iframe src="https://example.com"></iframe>`},
+		{"event handler", `<img src="x.png" onerror="alert(1)">`},
+		{"javascript link", "[click](javascript:alert(1))"},
+		{"javascript link uppercase", "[click](JAVASCRIPT:alert(1))"},
+		{"javascript link entity", "[click](jav&#x61;script:alert(1))"},
+		{"javascript image", "![x](javascript:alert(1))"},
+		{"javascript autolink", "<javascript:alert(1)>"},
+		{"data link", "[click](data:text/html;base64,PHNjcmlwdD5hbGVydCgxKTwvc2NyaXB0Pg==)"},
+		{"style tag", "<style>body{display:none}</style>"},
+		{"object", `<object data="x.swf"></object>`},
+		{"form", `<form action="https://example.com"><input type="text"></form>`},
+		{"table cell script", "| a |\n|---|\n| <script>alert(1)</script> |"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var output = strings.ToLower(Render(test.source))
+
+			// text that merely reads like a URL is fine, it just can't end up in a link or an attribute
+			for _, forbidden := range []string{"<script", "<iframe", "<style", "<object", "<form", "onerror=",
+				`href="javascript:`, `src="javascript:`, `href="data:`} {
+				if strings.Contains(output, forbidden) {
+					t.Errorf("Render(%q) = %q, contains %q", test.source, output, forbidden)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderKeepsMarkdown(t *testing.T) {
+	var tests = []struct {
+		source string
+		want   string
+	}{
+		{"**bold**", "<strong>bold</strong>"},
+		{"~~gone~~", "<del>gone</del>"},
+		{"[docs](https://example.com/docs)", `href="https://example.com/docs"`},
+		{"[docs](https://example.com/docs)", `rel="nofollow noopener"`},
+		{"[relative](/projects/x)", `href="/projects/x"`},
+		{"```mcfunction\nsay hi\n```", `<code class="language-mcfunction">`},
+		{"| a |\n|:-:|\n| b |", `<td align="center">b</td>`},
+		{"- [x] done", `checked`},
+	}
+
+	for _, test := range tests {
+		if output := Render(test.source); !strings.Contains(output, test.want) {
+			t.Errorf("Render(%q) = %q, want it to contain %q", test.source, output, test.want)
+		}
+	}
+}
+
+func TestRenderEmpty(t *testing.T) {
+	if output := Render(""); output != "" {
+		t.Errorf("Render(\"\") = %q, want empty", output)
+	}
+}
+
+func TestRenderHeadingIDs(t *testing.T) {
+	var output = Render("# Body\n\nsee [below](#forms)\n\n## forms")
+
+	for _, want := range []string{`<h1 id="user-content-body">`, `<h2 id="user-content-forms">`, `href="#user-content-forms"`} {
+		if !strings.Contains(output, want) {
+			t.Errorf("Render() = %q, want it to contain %q", output, want)
+		}
+	}
+}
+
+func TestRenderImages(t *testing.T) {
+	var tests = []struct {
+		name   string
+		policy imagePolicy
+		source string
+		want   string
+	}{
+		{"relative", imagePolicy{}, "![icon](/files/icons/x.webp)", `<img src="/files/icons/x.webp" alt="icon">`},
+		{"off-site by default", imagePolicy{}, "![shot](https://evil.example/x.png)", `<a href="https://evil.example/x.png" rel="nofollow noopener" target="_blank">shot</a>`},
+		{"allowed host", imagePolicy{hosts: []string{"imgur.com"}}, "![shot](https://i.imgur.com/x.png)", `<img src="https://i.imgur.com/x.png" alt="shot">`},
+		{"host not allowed", imagePolicy{hosts: []string{"imgur.com"}}, "![shot](https://notimgur.com/x.png)", `<a href="https://notimgur.com/x.png"`},
+		{"proxied", imagePolicy{proxy: "https://proxy.example/?url="}, "![shot](https://evil.example/x.png)", `<img src="https://proxy.example/?url=https%3A%2F%2Fevil.example%2Fx.png" alt="shot">`},
+		{"not http", imagePolicy{proxy: "https://proxy.example/?url="}, "![shot](ftp://evil.example/x.png)", `shot`},
+	}
+
+	var configured = images
+	defer func() { images = configured }()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			images = test.policy
+
+			var output = Render(test.source)
+			if !strings.Contains(output, test.want) {
+				t.Errorf("Render(%q) = %q, want it to contain %q", test.source, output, test.want)
+			}
+			if !strings.Contains(test.want, "<img") && strings.Contains(output, "<img") {
+				t.Errorf("Render(%q) = %q, want no inline image", test.source, output)
+			}
+		})
+	}
+}
@@ -3,7 +3,9 @@ package paging
 import (
 	"github.com/labstack/echo/v4"
 	"net/http"
+	"slices"
 	"strconv"
+	"time"
 )
 
 const (
@@ -36,3 +38,91 @@ func GetPaginationModel(c echo.Context) (int, int, error) {
 	offset := page * limit
 	return limit, offset, nil
 }
+
+// Request describes which page of a listing to fetch.
+// When Cursor is set it takes precedence over Offset.
+type Request struct {
+	Limit  int
+	Offset int
+	Cursor *Cursor
+	Scope  string
+}
+
+// GetPageRequest reads page/limit and the optional cursor query parameters.
+// scope should come from Scope and identify the listing being paged.
+func GetPageRequest(c echo.Context, scope string) (Request, error) {
+	limit, offset, err := GetPaginationModel(c)
+	if err != nil {
+		return Request{}, err
+	}
+
+	var req = Request{Limit: limit, Offset: offset, Scope: scope}
+
+	if rawCursor := c.QueryParam("cursor"); rawCursor != "" {
+		req.Cursor, err = DecodeCursor(rawCursor, scope)
+		if err != nil {
+			return Request{}, echo.NewHTTPError(http.StatusBadRequest, "invalid cursor for this query")
+		}
+		req.Offset = 0
+	}
+
+	return req, nil
+}
+
+// Keyed is implemented by rows that can be paged with cursors.
+type Keyed interface {
+	// CursorKey returns the sort key and id of the row.
+	CursorKey() (string, string)
+}
+
+// Page is the shared envelope returned by listing endpoints.
+type Page[T any] struct {
+	Time       float64 `json:"time"` // Query time in seconds
+	Count      int     `json:"count"`
+	Total      *int    `json:"total,omitempty"` // Matching rows across all pages, when known
+	NextCursor *string `json:"next_cursor"`
+	PrevCursor *string `json:"prev_cursor"`
+	Results    []T     `json:"results"`
+}
+
+// NewPage builds the envelope from rows fetched with Request.Limit+1,
+// in the order the query returned them (reversed for backward cursors).
+func NewPage[T Keyed](req Request, rows []T, startTime time.Time) Page[T] {
+	var backward = req.Cursor != nil && req.Cursor.Backward
+
+	var hasMore = len(rows) > req.Limit
+	if hasMore {
+		rows = rows[:req.Limit]
+	}
+	if backward {
+		slices.Reverse(rows)
+	}
+	if rows == nil {
+		rows = []T{}
+	}
+
+	var page = Page[T]{Count: len(rows), Results: rows}
+
+	if len(rows) > 0 {
+		var hasNext, hasPrev bool
+		if backward {
+			hasNext, hasPrev = true, hasMore
+		} else {
+			hasNext, hasPrev = hasMore, req.Cursor != nil || req.Offset > 0
+		}
+
+		if hasNext {
+			key, id := rows[len(rows)-1].CursorKey()
+			next := Cursor{Scope: req.Scope, Key: key, ID: id}.Encode()
+			page.NextCursor = &next
+		}
+		if hasPrev {
+			key, id := rows[0].CursorKey()
+			prev := Cursor{Scope: req.Scope, Key: key, ID: id, Backward: true}.Encode()
+			page.PrevCursor = &prev
+		}
+	}
+
+	page.Time = time.Since(startTime).Seconds()
+	return page
+}
@@ -0,0 +1,97 @@
+// Package semver orders version codes the way semantic versioning does, e.g. 1.10.0 after 1.9.2
+// and 2.0.0-beta.2 before 2.0.0. It is lenient about what it accepts, a leading v, fewer or more
+// than three numbers and build metadata are all fine.
+package semver
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Codes that don't start with a number can't be ordered by their content, they all share the lowest
+// sort key so that ties are broken by upload time instead.
+const unordered = ""
+
+// the markers are picked so that, compared byte by byte, a pre-release sorts before its release,
+// and a release before a version with more numbers, e.g. 1.2-beta < 1.2 < 1.2.1
+const (
+	preReleaseMarker = "!"
+	releaseMarker    = "#"
+	numberSeparator  = "."
+	// below every character allowed in a pre-release identifier, so shorter identifiers sort first
+	identifierSeparator = " "
+)
+
+// SortKey returns a string that sorts byte by byte (COLLATE "C" in Postgres) in semantic version order.
+func SortKey(code string) string {
+	var version = strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(code), "v"), "V")
+
+	// build metadata doesn't take part in ordering
+	version, _, _ = strings.Cut(version, "+")
+	core, preRelease, hasPreRelease := strings.Cut(version, "-")
+
+	var numbers = strings.Split(core, ".")
+	for len(numbers) < 3 {
+		numbers = append(numbers, "0")
+	}
+
+	var key strings.Builder
+	for i, number := range numbers {
+		encoded, ok := encodeNumber(number)
+		if !ok {
+			return unordered
+		}
+		if i > 0 {
+			key.WriteString(numberSeparator)
+		}
+		key.WriteString(encoded)
+	}
+
+	if !hasPreRelease {
+		key.WriteString(releaseMarker)
+		return key.String()
+	}
+
+	key.WriteString(preReleaseMarker)
+	for i, identifier := range strings.Split(preRelease, ".") {
+		if i > 0 {
+			key.WriteString(identifierSeparator)
+		}
+		// numeric identifiers sort numerically and before alphanumeric ones
+		if encoded, ok := encodeNumber(identifier); ok {
+			key.WriteString("0" + encoded)
+		} else {
+			key.WriteString("1" + identifier)
+		}
+	}
+
+	return key.String()
+}
+
+// Compare returns -1, 0 or 1 depending on whether a sorts before, the same as, or after b.
+// Codes that aren't semantic versions sort before all that are, and the same as each other.
+func Compare(a string, b string) int {
+	return strings.Compare(SortKey(a), SortKey(b))
+}
+
+// encodeNumber prefixes the digits of number with their count, so longer numbers sort after shorter ones.
+func encodeNumber(number string) (string, bool) {
+	if number == "" {
+		return "", false
+	}
+	for _, char := range number {
+		if char < '0' || char > '9' {
+			return "", false
+		}
+	}
+
+	number = strings.TrimLeft(number, "0")
+	if number == "" {
+		number = "0"
+	}
+	if len(number) > 99 {
+		return "", false
+	}
+
+	return strconv.Itoa(len(number)/10) + strconv.Itoa(len(number)%10) + number, true
+}
@@ -0,0 +1,67 @@
+package semver
+
+import "testing"
+
+func TestSortKeyOrder(t *testing.T) {
+	// every code sorts before the next one
+	var ordered = []string{
+		"1.0.0-alpha",
+		"1.0.0-alpha.1",
+		"1.0.0-alpha.beta",
+		"1.0.0-beta",
+		"1.0.0-beta.2",
+		"1.0.0-beta.11",
+		"1.0.0-rc.1",
+		"1.0.0",
+		"1.0.1",
+		"1.2-beta",
+		"1.2",
+		"1.2.1",
+		"1.2.3.4.5",
+		"1.9.2",
+		"1.10.0",
+		"2.0.0-beta.2",
+		"2.0.0",
+		"10.0.0",
+	}
+
+	for i := 1; i < len(ordered); i++ {
+		if Compare(ordered[i-1], ordered[i]) >= 0 {
+			t.Errorf("expected %q < %q, keys %q and %q", ordered[i-1], ordered[i], SortKey(ordered[i-1]), SortKey(ordered[i]))
+		}
+	}
+}
+
+func TestSortKeyEqual(t *testing.T) {
+	var tests = []struct {
+		a string
+		b string
+	}{
+		{"1.2.3", "v1.2.3"},
+		{"1.2.3", "V1.2.3"},
+		{"1.2.3", "1.2.3+build.7"},
+		{"1.2.3", " 1.2.3 "},
+		{"1.2", "1.2.0"},
+		{"1", "1.0.0"},
+		{"01.002.3", "1.2.3"},
+	}
+
+	for _, test := range tests {
+		if Compare(test.a, test.b) != 0 {
+			t.Errorf("expected %q == %q, keys %q and %q", test.a, test.b, SortKey(test.a), SortKey(test.b))
+		}
+	}
+}
+
+func TestSortKeyUnordered(t *testing.T) {
+	for _, code := range []string{"", "latest", "beta", "1.x", "1..2", "release-1"} {
+		if key := SortKey(code); key != unordered {
+			t.Errorf("SortKey(%q) = %q, want %q", code, key, unordered)
+		}
+	}
+
+	// codes that aren't versions sort before all that are
+	if Compare("latest", "0.0.1-alpha") >= 0 {
+		t.Errorf("expected non-version codes to sort first")
+	}
+}
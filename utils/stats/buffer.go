@@ -0,0 +1,113 @@
+package stats
+
+import (
+	"sync"
+	"time"
+
+	"github.com/HoodieRocks/dph-api-2/utils/db"
+	"github.com/labstack/gommon/log"
+)
+
+// FlushInterval is the longest a download waits in memory before it is written,
+// which bounds how many downloads a crash can lose.
+const FlushInterval = 5 * time.Second
+
+// FlushSize is how many buffered downloads trigger a flush before FlushInterval is up.
+const FlushSize = 500
+
+// MaxBufferedDownloads is how many downloads are held on to while the database is failing,
+// past that the oldest are dropped so memory stays bounded.
+const MaxBufferedDownloads = 50000
+
+// DownloadBuffer collects download events in memory and writes them in periodic batches, so popular
+// packs don't take a transaction and a row lock on their project for every single download.
+type DownloadBuffer struct {
+	mu     sync.Mutex
+	events []db.DownloadEvent
+
+	full chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Downloads is the buffer the download routes add to, see Start and Close.
+var Downloads = NewDownloadBuffer()
+
+func NewDownloadBuffer() *DownloadBuffer {
+	return &DownloadBuffer{
+		full: make(chan struct{}, 1),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+// Add buffers a download, it is logged and counted by the next flush.
+func (buffer *DownloadBuffer) Add(event db.DownloadEvent) {
+	buffer.mu.Lock()
+	buffer.events = append(buffer.events, event)
+	var size = len(buffer.events)
+	buffer.mu.Unlock()
+
+	if size >= FlushSize {
+		select {
+		case buffer.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Flush writes every buffered download now. If that fails the downloads are put back for the next try.
+func (buffer *DownloadBuffer) Flush() error {
+	buffer.mu.Lock()
+	var events = buffer.events
+	buffer.events = nil
+	buffer.mu.Unlock()
+
+	if len(events) == 0 {
+		return nil
+	}
+
+	_, err := db.EstablishConnection().FlushDownloads(events)
+
+	if err != nil {
+		buffer.mu.Lock()
+		buffer.events = append(events, buffer.events...)
+		if dropped := len(buffer.events) - MaxBufferedDownloads; dropped > 0 {
+			log.Warnf("dropping %d buffered downloads\n", dropped)
+			buffer.events = buffer.events[dropped:]
+		}
+		buffer.mu.Unlock()
+	}
+
+	return err
+}
+
+// Start flushes the buffer every FlushInterval, or sooner when it fills up, until Close is called.
+func (buffer *DownloadBuffer) Start() {
+	go func() {
+		defer close(buffer.done)
+
+		var ticker = time.NewTicker(FlushInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-buffer.stop:
+				return
+			case <-ticker.C:
+			case <-buffer.full:
+			}
+
+			if err := buffer.Flush(); err != nil {
+				log.Errorf("failed to flush downloads: %v\n", err)
+			}
+		}
+	}()
+}
+
+// Close stops the flush loop and writes what is left. Call it once the server no longer takes requests.
+func (buffer *DownloadBuffer) Close() error {
+	close(buffer.stop)
+	<-buffer.done
+	return buffer.Flush()
+}
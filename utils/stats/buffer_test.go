@@ -0,0 +1,131 @@
+package stats
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/HoodieRocks/dph-api-2/utils/db"
+)
+
+// These benchmarks need a database to write to, run them with POSTGRES_URL set:
+//
+//	POSTGRES_URL=postgres://... go test ./utils/stats -run '^$' -bench Downloads
+
+const benchID = "bench-downloads"
+
+// setupBenchmark creates a user, project and version for the benchmark to download, and removes them
+// along with the logged downloads afterwards.
+func setupBenchmark(b *testing.B) db.Version {
+	if os.Getenv("POSTGRES_URL") == "" {
+		b.Skip("POSTGRES_URL is not set")
+	}
+
+	var conn = db.EstablishConnection()
+	db.CreateTables(conn)
+
+	var ctx = context.Background()
+	var statements = []string{
+		`INSERT INTO users (id, username, role, bio, join_date, password, token)
+			VALUES ('` + benchID + `', '` + benchID + `', 'default', '', NOW(), '', '` + benchID + `') ON CONFLICT DO NOTHING`,
+		`INSERT INTO projects (id, title, slug, author, description, body, creation, updated, category, status)
+			VALUES ('` + benchID + `', '` + benchID + `', '` + benchID + `', '` + benchID + `', '', '', NOW(), NOW(), '{}', 'live') ON CONFLICT DO NOTHING`,
+		`INSERT INTO versions (id, title, description, creation, download_link, version_code, supports, project)
+			VALUES ('` + benchID + `', '` + benchID + `', '', NOW(), '/files/` + benchID + `.zip', '1.0.0', '{}', '` + benchID + `') ON CONFLICT DO NOTHING`,
+	}
+
+	for _, statement := range statements {
+		if _, err := conn.Db.Exec(ctx, statement); err != nil {
+			b.Fatalf("failed to create benchmark rows: %v", err)
+		}
+	}
+
+	b.Cleanup(func() {
+		for _, statement := range []string{
+			`DELETE FROM download_events WHERE version = '` + benchID + `'`,
+			`DELETE FROM versions WHERE id = '` + benchID + `'`,
+			`DELETE FROM projects WHERE id = '` + benchID + `'`,
+			`DELETE FROM users WHERE id = '` + benchID + `'`,
+		} {
+			conn.Db.Exec(ctx, statement)
+		}
+	})
+
+	return db.Version{ID: benchID, Project: benchID}
+}
+
+// benchmarkEvent makes a download by a different client every call, so none are deduplicated.
+func benchmarkEvent(version db.Version, counter *atomic.Int64) db.DownloadEvent {
+	var now = time.Now().UTC()
+
+	return db.DownloadEvent{
+		Version:  version.ID,
+		Project:  version.Project,
+		Primary:  true,
+		Day:      now.Truncate(24 * time.Hour),
+		Window:   now.Truncate(DedupWindow),
+		Client:   ClientUnknown,
+		Referrer: ReferrerDirect,
+		Visitor:  strconv.FormatInt(now.UnixNano(), 36) + "-" + strconv.FormatInt(counter.Add(1), 36),
+	}
+}
+
+// BenchmarkDownloadsPerRequest is how downloads used to be counted: a transaction per download that logs
+// it and updates the version and project rows.
+func BenchmarkDownloadsPerRequest(b *testing.B) {
+	var version = setupBenchmark(b)
+	var conn = db.EstablishConnection()
+	var counter atomic.Int64
+
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			tx, err := conn.Db.Begin(context.Background())
+			if err != nil {
+				b.Error(err)
+				return
+			}
+
+			recorded, err := conn.RecordDownload(tx, benchmarkEvent(version, &counter))
+			if err == nil && recorded {
+				err = conn.CountDownload(tx, version.ID, version.Project)
+			}
+
+			if err != nil {
+				tx.Rollback(context.Background())
+				b.Error(err)
+				return
+			}
+
+			if err = tx.Commit(context.Background()); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+// BenchmarkDownloadsBuffered counts downloads through a DownloadBuffer, including the final flush.
+func BenchmarkDownloadsBuffered(b *testing.B) {
+	var version = setupBenchmark(b)
+	var buffer = NewDownloadBuffer()
+	var counter atomic.Int64
+
+	b.ResetTimer()
+
+	buffer.Start()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			buffer.Add(benchmarkEvent(version, &counter))
+		}
+	})
+
+	if err := buffer.Close(); err != nil {
+		b.Fatal(err)
+	}
+}
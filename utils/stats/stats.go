@@ -0,0 +1,190 @@
+// Package stats turns download requests into privacy-preserving download events and rolls them up
+// into the daily stats authors see.
+package stats
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HoodieRocks/dph-api-2/utils/db"
+	"github.com/labstack/gommon/log"
+)
+
+// DedupWindow is how long repeat downloads of a version by the same client count only once.
+// Windows are aligned to the clock, not to the first download.
+const DedupWindow = time.Hour
+
+// RollupInterval is how often download events are rolled up, so stats lag behind by at most this much.
+const RollupInterval = 15 * time.Minute
+
+// EventRetention is how many past days of raw events are kept after being rolled up.
+const EventRetention = 2
+
+// Client types, picked from the User-Agent.
+const (
+	ClientBrowser  = "browser"
+	ClientLauncher = "launcher"
+	ClientTool     = "tool"
+	ClientBot      = "bot"
+	ClientUnknown  = "unknown"
+)
+
+// Referrer types, picked from the Referer header.
+const (
+	ReferrerDirect = "direct"
+	ReferrerSearch = "search"
+	ReferrerSocial = "social"
+	ReferrerOther  = "other"
+)
+
+var launcherMarkers = []string{"prismlauncher", "multimc", "atlauncher", "modrinth", "curseforge", "gdlauncher", "polymc", "technic"}
+var toolMarkers = []string{"curl", "wget", "python", "go-http-client", "java", "okhttp", "node", "httpie", "powershell"}
+var botMarkers = []string{"bot", "crawler", "spider", "slurp", "preview"}
+
+// Hosts are matched on whole domain labels, so x.com doesn't match netflix.com. Entries ending in a dot
+// match any domain they start, e.g. google. matches google.com and www.google.co.uk.
+var searchHosts = []string{"google.", "bing.", "duckduckgo.", "yandex.", "baidu.", "ecosia.", "search.brave."}
+var socialHosts = []string{"discord.", "reddit.", "twitter.", "x.com", "youtube.", "youtu.be", "facebook.", "tiktok.", "planetminecraft."}
+
+// ClientType sorts a User-Agent into one of the coarse client types.
+func ClientType(userAgent string) string {
+	var ua = strings.ToLower(userAgent)
+
+	switch {
+	case ua == "":
+		return ClientUnknown
+	case containsAny(ua, botMarkers):
+		return ClientBot
+	case containsAny(ua, launcherMarkers):
+		return ClientLauncher
+	case containsAny(ua, toolMarkers):
+		return ClientTool
+	case strings.HasPrefix(ua, "mozilla/"):
+		return ClientBrowser
+	}
+
+	return ClientUnknown
+}
+
+// ReferrerType sorts a Referer into one of the coarse referrer types, the full URL is never stored.
+func ReferrerType(referer string) string {
+	if referer == "" {
+		return ReferrerDirect
+	}
+
+	parsed, err := url.Parse(referer)
+	if err != nil || parsed.Hostname() == "" {
+		return ReferrerOther
+	}
+
+	var host = strings.ToLower(parsed.Hostname())
+
+	switch {
+	case matchesAnyHost(host, searchHosts):
+		return ReferrerSearch
+	case matchesAnyHost(host, socialHosts):
+		return ReferrerSocial
+	}
+
+	return ReferrerOther
+}
+
+func containsAny(value string, markers []string) bool {
+	for _, marker := range markers {
+		if strings.Contains(value, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAnyHost(host string, domains []string) bool {
+	for _, domain := range domains {
+		if strings.HasSuffix(domain, ".") {
+			if strings.HasPrefix(host, domain) || strings.Contains(host, "."+domain) {
+				return true
+			}
+		} else if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+var salts = struct {
+	sync.Mutex
+	day  time.Time
+	salt []byte
+}{}
+
+// dailySalt returns the salt for day, which is shared through the database so every instance hashes alike.
+func dailySalt(day time.Time) ([]byte, error) {
+	salts.Lock()
+	defer salts.Unlock()
+
+	if salts.salt != nil && salts.day.Equal(day) {
+		return salts.salt, nil
+	}
+
+	salt, err := db.EstablishConnection().GetDownloadSalt(day)
+	if err != nil {
+		return nil, err
+	}
+
+	salts.day, salts.salt = day, salt
+	return salt, nil
+}
+
+// NewDownloadEvent describes a download of a file of version by the client that made request. The client is
+// only identified by a hash of its IP address and User-Agent, salted with a secret that is thrown away once the
+// day is over. A file without an id is a version from before version_files, it counts as the primary file.
+func NewDownloadEvent(request *http.Request, ip string, version db.Version, file db.VersionFile) (db.DownloadEvent, error) {
+	var now = time.Now().UTC()
+	var day = now.Truncate(24 * time.Hour)
+	var userAgent = request.UserAgent()
+
+	salt, err := dailySalt(day)
+	if err != nil {
+		return db.DownloadEvent{}, err
+	}
+
+	mac := hmac.New(sha256.New, salt)
+	mac.Write([]byte(ip + "\x00" + userAgent))
+
+	return db.DownloadEvent{
+		Version:  version.ID,
+		Project:  version.Project,
+		File:     file.ID,
+		Primary:  file.ID == "" || file.Role == db.PrimaryFile,
+		Day:      day,
+		Window:   now.Truncate(DedupWindow),
+		Client:   ClientType(userAgent),
+		Referrer: ReferrerType(request.Referer()),
+		Visitor:  hex.EncodeToString(mac.Sum(nil)),
+	}, nil
+}
+
+// RunRollups rolls download events up into daily stats every RollupInterval until ctx is cancelled.
+func RunRollups(ctx context.Context) {
+	var ticker = time.NewTicker(RollupInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := db.EstablishConnection().RollupDownloads(EventRetention); err != nil {
+			log.Errorf("failed to roll up downloads: %v\n", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package stats
+
+import "testing"
+
+func TestClientType(t *testing.T) {
+	var tests = []struct {
+		userAgent string
+		want      string
+	}{
+		{"", ClientUnknown},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Safari/537.36", ClientBrowser},
+		{"Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0", ClientBrowser},
+		{"PrismLauncher/8.0", ClientLauncher},
+		{"modrinth/theseus/0.6.0 (support@modrinth.com)", ClientLauncher},
+		{"curl/8.4.0", ClientTool},
+		{"Wget/1.21", ClientTool},
+		{"python-requests/2.31.0", ClientTool},
+		{"Go-http-client/1.1", ClientTool},
+		{"Java/17.0.2", ClientTool},
+		{"okhttp/4.12.0", ClientTool},
+		{"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", ClientBot},
+		{"Mozilla/5.0 (compatible; Discordbot/2.0; +https://discordapp.com)", ClientBot},
+		{"Mozilla/5.0 (compatible; bingpreview/2.0)", ClientBot},
+		{"SomethingElse/1.0", ClientUnknown},
+	}
+
+	for _, test := range tests {
+		if got := ClientType(test.userAgent); got != test.want {
+			t.Errorf("ClientType(%q) = %q, want %q", test.userAgent, got, test.want)
+		}
+	}
+}
+
+func TestReferrerType(t *testing.T) {
+	var tests = []struct {
+		referer string
+		want    string
+	}{
+		{"", ReferrerDirect},
+		{"https://www.google.com/search?q=datapack", ReferrerSearch},
+		{"https://www.google.co.uk/", ReferrerSearch},
+		{"https://duckduckgo.com/", ReferrerSearch},
+		{"https://search.brave.com/search?q=x", ReferrerSearch},
+		{"https://x.com/someone/status/1", ReferrerSocial},
+		{"https://mobile.x.com/someone", ReferrerSocial},
+		{"https://www.reddit.com/r/MinecraftCommands", ReferrerSocial},
+		{"https://youtu.be/abc", ReferrerSocial},
+		{"https://discord.com/channels/1", ReferrerSocial},
+		{"https://www.planetminecraft.com/data-pack/x/", ReferrerSocial},
+		{"https://www.netflix.com/", ReferrerOther},
+		{"https://dropbox.com/s/x", ReferrerOther},
+		{"https://notgoogle.com/", ReferrerOther},
+		{"https://example.com/google.com", ReferrerOther},
+		{"not a url", ReferrerOther},
+		{"://broken", ReferrerOther},
+	}
+
+	for _, test := range tests {
+		if got := ReferrerType(test.referer); got != test.want {
+			t.Errorf("ReferrerType(%q) = %q, want %q", test.referer, got, test.want)
+		}
+	}
+}